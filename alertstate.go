@@ -0,0 +1,84 @@
+package logwatcher
+
+import (
+	"time"
+)
+
+// alertEvent is what an evaluation of an alert reports.
+type alertEvent int
+
+const (
+	eventNone alertEvent = iota
+	eventFire
+	eventRecover
+	// eventFlapStart is reported instead of the state change making the
+	// alert flap, and the state changes are not reported until
+	// eventFlapEnd.
+	eventFlapStart
+	eventFlapEnd
+)
+
+// alertTracker applies the pending duration of an alert, and detects when it
+// flaps. An alert fires once its condition has held for the pending
+// duration, and flaps once it changes state flapChanges times within
+// flapWindow. Flapping ends once the alert keeps its state for a whole flap
+// window. A zero flap window never flaps.
+type alertTracker struct {
+	pending     time.Duration
+	flapWindow  time.Duration
+	flapChanges int
+
+	pendingSince time.Time
+	changes      []time.Time
+	flapping     bool
+}
+
+// step evaluates an alert at now, given whether it fires, whether its firing
+// condition holds and whether its recovering condition holds. It returns
+// whether the alert fires after the evaluation, and the event to report.
+func (t *alertTracker) step(firing, breach, recover bool, now time.Time) (bool, alertEvent) {
+	next := firing
+	switch {
+	case !firing && breach:
+		if t.pendingSince.IsZero() {
+			t.pendingSince = now
+		}
+		next = now.Sub(t.pendingSince) >= t.pending
+	case !firing:
+		t.pendingSince = time.Time{}
+	case recover:
+		next = false
+	}
+
+	for len(t.changes) > 0 && now.Sub(t.changes[0]) >= t.flapWindow {
+		t.changes = t.changes[1:]
+	}
+	if next == firing {
+		if t.flapping && len(t.changes) == 0 {
+			t.flapping = false
+			return next, eventFlapEnd
+		}
+		return next, eventNone
+	}
+
+	t.pendingSince = time.Time{}
+	if t.flapWindow > 0 {
+		t.changes = append(t.changes, now)
+	}
+	switch {
+	case t.flapping:
+		return next, eventNone
+	case t.flapWindow > 0 && len(t.changes) >= t.flapChanges:
+		t.flapping = true
+		return next, eventFlapStart
+	case next:
+		return next, eventFire
+	}
+	return next, eventRecover
+}
+
+// isPending tells whether the firing condition holds, but not for the pending
+// duration yet.
+func (t *alertTracker) isPending() bool {
+	return !t.pendingSince.IsZero()
+}
@@ -0,0 +1,107 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type AlertStateSuite struct{}
+
+var _ = Suite(&AlertStateSuite{})
+
+// stepAlert steps t once per second from windowStart with the breaches
+// given, recovering when they do not breach, and returns the events.
+func stepAlert(t *alertTracker, breaches ...bool) []alertEvent {
+	events := make([]alertEvent, 0)
+	firing := false
+	for i, breach := range breaches {
+		var event alertEvent
+		firing, event = t.step(firing, breach, !breach, windowStart.Add(time.Duration(i)*time.Second))
+		events = append(events, event)
+	}
+	return events
+}
+
+func (s *AlertStateSuite) TestAlertTrackerPending(c *C) {
+	t := &alertTracker{pending: 2 * time.Second}
+	c.Assert(stepAlert(t, true, true, false, true, true, true, false), DeepEquals,
+		[]alertEvent{eventNone, eventNone, eventNone, eventNone, eventNone, eventFire, eventRecover})
+	c.Assert(t.isPending(), Equals, false)
+}
+
+func (s *AlertStateSuite) TestAlertTrackerFlapping(c *C) {
+	t := &alertTracker{flapWindow: 5 * time.Second, flapChanges: 3}
+	events := stepAlert(t, true, false, true, false, true, true, true, true, true, true, false)
+	c.Assert(events, DeepEquals, []alertEvent{eventFire, eventRecover, eventFlapStart, eventNone, eventNone,
+		eventNone, eventNone, eventNone, eventNone, eventFlapEnd, eventRecover})
+	c.Assert(t.flapping, Equals, false)
+}
+
+func (s *AlertStateSuite) TestStatsAggregatorAlertHysteresis(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 10, AlertThreshold: 10,
+		AlertFor: 2, AlertRecoverThreshold: 5})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+
+	// 30 hits a second for 5s, 8 for 10s then 3.
+	alerts := make([]Alert, 0)
+	for t := 1; t <= 30; t++ {
+		hits := 3
+		switch {
+		case t <= 5:
+			hits = 30
+		case t <= 15:
+			hits = 8
+		}
+		for i := 0; i < hits; i++ {
+			shard.Add(CommonLog{Status: 200, Time: windowStart.Add(time.Duration(t-1) * time.Second)})
+		}
+		raised, snap := agg.Alert(windowStart.Add(time.Duration(t) * time.Second))
+		alerts = append(alerts, raised...)
+		c.Assert(snap.AlertPending, Equals, t == 4 || t == 5, Commentf("at %ds", t))
+	}
+
+	// The average goes above 10 at 4s and fires 2s later. It stays firing
+	// while between the thresholds, from 15s to 19s, and recovers at 20s.
+	c.Assert(alerts, HasLen, 2)
+	c.Assert(alerts[0].Time.Equal(windowStart.Add(6*time.Second)), Equals, true)
+	c.Assert(alerts[0].AvgHits, Equals, 15)
+	c.Assert(alerts[1].Recovered, Equals, true)
+	c.Assert(alerts[1].Time.Equal(windowStart.Add(20*time.Second)), Equals, true)
+	c.Assert(alerts[1].AvgHits, Equals, 5)
+}
+
+func (s *AlertStateSuite) TestStatsAggregatorAlertFlapping(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 1, AlertThreshold: 10,
+		FlapWindow: 10, FlapThreshold: 4})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+
+	// Traffic toggling around the threshold every second for 8s, then high.
+	alerts := make([]Alert, 0)
+	for t := 1; t <= 25; t++ {
+		hits := 20
+		if t <= 8 && t%2 == 0 {
+			hits = 5
+		}
+		for i := 0; i < hits; i++ {
+			shard.Add(CommonLog{Status: 200, Time: windowStart.Add(time.Duration(t-1) * time.Second)})
+		}
+		raised, _ := agg.Alert(windowStart.Add(time.Duration(t) * time.Second))
+		alerts = append(alerts, raised...)
+	}
+
+	// Three changes are reported, the fourth collapses the next ones into
+	// one event, and flapping stops 10s after the last change at 9s.
+	c.Assert(alerts, HasLen, 5)
+	c.Assert(alerts[3].Flapping, Equals, true)
+	c.Assert(alerts[3].Message, Matches, `High traffic alert is flapping - 4 changes in 10s, alerts suppressed, average hits = 5, .*`)
+	c.Assert(alerts[4].Flapping, Equals, true)
+	c.Assert(alerts[4].Time.Equal(windowStart.Add(19*time.Second)), Equals, true)
+	c.Assert(alerts[4].Message, Matches, `High traffic alert stopped flapping - average hits = 20, .*`)
+	c.Assert(lw.AlertState, Equals, true)
+	c.Assert(lw.AlertMsg, HasLen, 5)
+}
@@ -0,0 +1,126 @@
+package logwatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Benchmarks of the ingestion of combined log lines, from the queue of the
+// lines read to the aggregator, reporting the lines per second. Run them with
+//
+//	go test -run XXX -bench Ingest -benchmem
+//
+// and the parsers alone with -bench Parse.
+
+var benchLines = []string{
+	`10.0.0.1 - frank [11/May/2016:22:02:21 +0200] "GET /api/users?id=42 HTTP/1.1" 200 512 "http://my.site.com/" "curl/7.47.0"`,
+	`10.0.0.2 - - [11/May/2016:22:02:22 +0200] "POST /pages/create HTTP/1.1" 201 1024 "-" "Mozilla/5.0 (X11; Linux x86_64)"`,
+	`10.0.0.3 - - [11/May/2016:22:02:23 +0200] "GET /assets/app.js HTTP/1.1" 304 0 "http://my.site.com/pages" "Mozilla/5.0"`,
+	`10.0.0.4 - - [11/May/2016:22:02:24 +0200] "GET /missing HTTP/1.1" 404 153 "-" "Googlebot/2.1"`,
+}
+
+// benchSource sends n lines.
+type benchSource struct {
+	n int
+}
+
+func (src benchSource) Open(ctx context.Context) error {
+	return nil
+}
+
+func (src benchSource) Run(ctx context.Context, out chan<- Line) {
+	for i := 0; i < src.n; i++ {
+		out <- Line{Source: "access.log", Text: benchLines[i%len(benchLines)]}
+	}
+}
+
+func reportLinesPerSecond(b *testing.B) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+// BenchmarkIngestBaseline parses the lines in a single goroutine, handing
+// them one at a time to the goroutine appending them to the pending lines,
+// as the pipeline did before parser workers and shards.
+func BenchmarkIngestBaseline(b *testing.B) {
+	b.ReportAllocs()
+	cfg := &Config{LogFormat: "combined"}
+	parser, _ := NewParser(cfg)
+	events := make(chan CommonLog)
+	done := make(chan []*CommonLog)
+	go func() {
+		logStats := make([]*CommonLog, 0)
+		for item := range events {
+			item := item
+			logStats = append(logStats, &item)
+		}
+		done <- logStats
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		item, err := parser.Parse(benchLines[i%len(benchLines)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		events <- *item
+	}
+	close(events)
+	<-done
+	reportLinesPerSecond(b)
+}
+
+// BenchmarkParse parses the lines with each parser engine, into a single
+// CommonLog when the parser allows it.
+func BenchmarkParse(b *testing.B) {
+	for _, engine := range []string{"regexp", "scan"} {
+		b.Run("engine="+engine, func(b *testing.B) {
+			b.ReportAllocs()
+			parser, _ := NewParser(&Config{LogFormat: "combined", ParserEngine: engine})
+			into, ok := parser.(IntoParser)
+			var item CommonLog
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if ok {
+					err = into.ParseInto(benchLines[i%len(benchLines)], &item)
+				} else {
+					_, err = parser.Parse(benchLines[i%len(benchLines)])
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportLinesPerSecond(b)
+		})
+	}
+}
+
+// BenchmarkIngestWorkers runs the pipeline read stage with the aggregator of
+// a Watcher, for several numbers of parser workers and both parser engines.
+func BenchmarkIngestWorkers(b *testing.B) {
+	for _, engine := range []string{"regexp", "scan"} {
+		for _, workers := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("engine=%s/workers=%d", engine, workers), func(b *testing.B) {
+				b.ReportAllocs()
+				cfg := &Config{LogFormat: "combined", RefreshInterval: 10, AlertInterval: 20,
+					QueueSize: 1024, ParserWorkers: workers, ParserEngine: engine}
+				lw, err := New(cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				p := NewPipeline(cfg, nil)
+				p.Sources = []Source{benchSource{n: b.N}}
+				p.Aggregator = newStatsAggregator(lw)
+				if err := p.Open(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+
+				b.ResetTimer()
+				p.read(context.Background())
+				reportLinesPerSecond(b)
+			})
+		}
+	}
+}
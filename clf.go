@@ -0,0 +1,224 @@
+package logwatcher
+
+import (
+	"strconv"
+	"time"
+)
+
+// IntoParser is implemented by parsers which parse a line into a CommonLog
+// given by the caller, so that it can be reused from one line to the next.
+type IntoParser interface {
+	Parser
+	ParseInto(line string, cl *CommonLog) error
+}
+
+// ScanLogParser parses the Common Log Format, or the NCSA Combined Log Format,
+// by scanning the bytes of the lines rather than with the regexp engine. It
+// accepts the lines the regexps of CommonLogParser accept, and parses them
+// alike, without allocating.
+type ScanLogParser struct {
+	combined bool
+	zones    map[int]*time.Location
+}
+
+// NewScanLogParser returns a scanning parser for the Common Log Format, or for
+// the Combined Log Format when combined is set.
+func NewScanLogParser(combined bool) *ScanLogParser {
+	return &ScanLogParser{combined: combined, zones: make(map[int]*time.Location)}
+}
+
+// Parse implements the Parser interface.
+func (p *ScanLogParser) Parse(line string) (*CommonLog, error) {
+	cl := &CommonLog{}
+	if err := p.ParseInto(line, cl); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// ParseInto implements the IntoParser interface.
+func (p *ScanLogParser) ParseInto(line string, cl *CommonLog) error {
+	*cl = CommonLog{}
+	s := logScanner{line: line}
+
+	cl.IP = s.run(isIPByte)
+	if cl.IP == "" || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	cl.Identifier = s.field()
+	if cl.Identifier == "" || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	cl.User = s.field()
+	if cl.User == "" || !s.skip(' ') || !s.skip('[') {
+		return ErrNoMatch
+	}
+	cl.Date = s.until(']')
+	if !s.skip(']') || !s.skip(' ') || !s.skip('"') {
+		return ErrNoMatch
+	}
+	cl.Method = s.field()
+	if cl.Method == "" || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	cl.Request = s.field()
+	if cl.Request == "" || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	// The protocol ends with the closing quote of the request, which the
+	// regexps match as the last byte of a field.
+	proto := s.field()
+	if len(proto) < 2 || proto[len(proto)-1] != '"' || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	cl.Proto = proto[:len(proto)-1]
+	status := s.run(isDigit)
+	if status == "" || !s.skip(' ') {
+		return ErrNoMatch
+	}
+	bytes := s.run(isDigit)
+	if bytes == "" && p.combined && s.skip('-') {
+		bytes = "-"
+	}
+	if bytes == "" {
+		return ErrNoMatch
+	}
+	cl.Status, _ = strconv.Atoi(status)
+	cl.Bytes, _ = strconv.ParseInt(bytes, 10, 64)
+
+	if p.combined {
+		if !s.skip(' ') || !s.skip('"') {
+			return ErrNoMatch
+		}
+		cl.Referrer = s.until('"')
+		if !s.skip('"') || !s.skip(' ') || !s.skip('"') {
+			return ErrNoMatch
+		}
+		cl.UserAgent = s.until('"')
+		if !s.skip('"') {
+			return ErrNoMatch
+		}
+	}
+
+	date, err := p.date(cl.Date)
+	if err != nil {
+		return err
+	}
+	cl.Time = date
+	return nil
+}
+
+var monthNames = [...]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// date parses a date of the Common Log Format. Dates written otherwise, which
+// time.Parse may still accept, are left to it.
+func (p *ScanLogParser) date(value string) (time.Time, error) {
+	if len(value) != len(CommonLogDate) || value[2] != '/' || value[6] != '/' || value[11] != ':' ||
+		value[14] != ':' || value[17] != ':' || value[20] != ' ' || (value[21] != '+' && value[21] != '-') {
+		return parseDate(value, CommonLogDate)
+	}
+	day, ok1 := digits(value[0:2])
+	year, ok2 := digits(value[7:11])
+	hour, ok3 := digits(value[12:14])
+	min, ok4 := digits(value[15:17])
+	sec, ok5 := digits(value[18:20])
+	zoneHour, ok6 := digits(value[22:24])
+	zoneMin, ok7 := digits(value[24:26])
+	month := 0
+	for i, name := range monthNames {
+		if value[3:6] == name {
+			month = i + 1
+		}
+	}
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7) || month == 0 || day < 1 ||
+		day > daysIn(time.Month(month), year) || hour > 23 || min > 59 || sec > 59 || zoneHour > 23 || zoneMin > 59 {
+		return parseDate(value, CommonLogDate)
+	}
+
+	offset := (zoneHour*60 + zoneMin) * 60
+	if value[21] == '-' {
+		offset = -offset
+	}
+	zone, ok := p.zones[offset]
+	if !ok {
+		zone = time.FixedZone("", offset)
+		p.zones[offset] = zone
+	}
+	// Like time.Parse, use the local zone when it has the offset of the date.
+	date := time.Date(year, time.Month(month), day, hour, min, sec, 0, zone)
+	if _, local := date.In(time.Local).Zone(); local == offset {
+		date = date.In(time.Local)
+	}
+	return date, nil
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// digits parses a string of decimal digits.
+func digits(s string) (int, bool) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return 0, false
+		}
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isIPByte(b byte) bool {
+	return isDigit(b) || b == '.'
+}
+
+// isSpace tells whether b is matched by \s in the regexps.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\f' || b == '\r'
+}
+
+// logScanner reads the fields of a log line from left to right.
+type logScanner struct {
+	line string
+	pos  int
+}
+
+// skip consumes b if it is the next byte.
+func (s *logScanner) skip(b byte) bool {
+	if s.pos < len(s.line) && s.line[s.pos] == b {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// run consumes the bytes matching fn.
+func (s *logScanner) run(fn func(byte) bool) string {
+	start := s.pos
+	for s.pos < len(s.line) && fn(s.line[s.pos]) {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
+
+// field consumes the bytes up to the next space, as \S+ does.
+func (s *logScanner) field() string {
+	start := s.pos
+	for s.pos < len(s.line) && !isSpace(s.line[s.pos]) {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
+
+// until consumes the bytes up to the next b.
+func (s *logScanner) until(b byte) string {
+	start := s.pos
+	for s.pos < len(s.line) && s.line[s.pos] != b {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
@@ -0,0 +1,114 @@
+package logwatcher
+
+import (
+	"reflect"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+type ScanParserSuite struct{}
+
+var _ = Suite(&ScanParserSuite{})
+
+func (s *ScanParserSuite) TestNewParserScan(c *C) {
+	p, err := NewParser(&Config{ParserEngine: "scan"})
+	c.Assert(err, IsNil)
+	c.Assert(p, DeepEquals, NewScanLogParser(false))
+	p, err = NewParser(&Config{LogFormat: "combined", ParserEngine: "scan"})
+	c.Assert(err, IsNil)
+	c.Assert(p, DeepEquals, NewScanLogParser(true))
+}
+
+func (s *ScanParserSuite) TestScanLogParserOk(c *C) {
+	for i := 0; i < 100; i++ {
+		line, expected := generateParsedLogLine()
+		cl, err := NewScanLogParser(false).Parse(line)
+		c.Assert(err, IsNil)
+		c.Assert(*cl, DeepEquals, *expected)
+	}
+}
+
+func (s *ScanParserSuite) TestScanLogParserCombinedOk(c *C) {
+	line := `127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /assets/avatars/avatar4.png HTTP/1.1" 304 - "http://my.site.com/pages" "Mozilla/5.0 (X11; Linux x86_64)"`
+
+	cl, err := NewScanLogParser(true).Parse(line)
+	c.Assert(err, IsNil)
+	expected, _ := NewCombinedLogParser().Parse(line)
+	c.Assert(*cl, DeepEquals, *expected)
+}
+
+func (s *ScanParserSuite) TestScanLogParserReuseOk(c *C) {
+	p := NewScanLogParser(true)
+	var cl CommonLog
+	c.Assert(p.ParseInto(`10.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET / HTTP/1.1" 200 5 "http://my.site.com/" "curl"`, &cl), IsNil)
+	c.Assert(p.ParseInto(`10.0.0.2 - - [11/May/2016:22:02:22 +0200] "GET /a HTTP/1.1" 404 0 "" ""`, &cl), IsNil)
+	c.Assert(cl.IP, Equals, "10.0.0.2")
+	c.Assert(cl.Referrer, Equals, "")
+	c.Assert(cl.UserAgent, Equals, "")
+}
+
+func (s *ScanParserSuite) TestScanLogParserKo(c *C) {
+	for _, line := range []string{
+		"",
+		"not a log line",
+		`127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET / HTTP/1.1"`,
+		`127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET / HTTP/1.1" 200 -`,
+		`127.0.0.1	- - [11/May/2016:22:02:21 +0200] "GET / HTTP/1.1" 200 0`,
+		`127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /" 200 0`,
+	} {
+		cl, err := NewScanLogParser(false).Parse(line)
+		c.Assert(cl, IsNil)
+		c.Assert(err, Equals, ErrNoMatch, Commentf("line %q", line))
+	}
+}
+
+func (s *ScanParserSuite) TestScanLogParserDateKo(c *C) {
+	cl, err := NewScanLogParser(false).Parse(`127.0.0.1 - - [31/Feb/2016:22:02:21 +0200] "GET / HTTP/1.1" 200 0`)
+	c.Assert(cl, IsNil)
+	c.Assert(err, ErrorMatches, `invalid date "31/Feb/2016:22:02:21 \+0200"`)
+}
+
+func (s *ScanParserSuite) TestScanLogParserAllocs(c *C) {
+	p := NewScanLogParser(true)
+	var cl CommonLog
+	allocs := testing.AllocsPerRun(100, func() {
+		p.ParseInto(benchLines[0], &cl)
+	})
+	c.Assert(allocs, Equals, float64(0))
+}
+
+// FuzzScanLogParser checks that the scanning parsers accept the lines the
+// regexp parsers accept, parse them alike, and reject the others the same way.
+func FuzzScanLogParser(f *testing.F) {
+	for _, line := range benchLines {
+		f.Add(line)
+	}
+	for _, line := range []string{
+		`127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /assets/avatars/avatar4.png HTTP/1.1" 304 0`,
+		`127.0.0.1 - - [11/may/2016:2:02:21.5 +0200] "GET / HTTP/1.1"" 200 12345678901234567890 trailer`,
+		`127.0.0.1 - - [11/05/2016 22:02:21] "GET / HTTP/1.1" 200 0`,
+		`1.2 a b [] "" 1 -  "" ""`,
+		"\xff \xfe \xfd [\x00] \"\xff \xff \xff\" 1 1",
+	} {
+		f.Add(line)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for _, combined := range []bool{false, true} {
+			regexp := NewCommonLogParser()
+			if combined {
+				regexp = NewCombinedLogParser()
+			}
+			expected, expectedErr := regexp.Parse(line)
+			cl, err := NewScanLogParser(combined).Parse(line)
+
+			if (err == nil) != (expectedErr == nil) || (err != nil && err.Error() != expectedErr.Error()) {
+				t.Fatalf("combined %v, line %q: got error %v, expected %v", combined, line, err, expectedErr)
+			}
+			if !reflect.DeepEqual(cl, expected) {
+				t.Fatalf("combined %v, line %q: got %+v, expected %+v", combined, line, cl, expected)
+			}
+		}
+	})
+}
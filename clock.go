@@ -0,0 +1,171 @@
+package logwatcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and drives the tickers of the application, either on
+// wall clock time or on the time of replayed log events.
+type Clock interface {
+	Now() time.Time
+	Tick(d time.Duration) <-chan time.Time
+}
+
+// WallClock is the Clock used when watching live logs.
+type WallClock struct{}
+
+// Now implements the Clock interface.
+func (WallClock) Now() time.Time {
+	return time.Now()
+}
+
+// Tick implements the Clock interface.
+func (WallClock) Tick(d time.Duration) <-chan time.Time {
+	return time.NewTicker(d).C
+}
+
+// ReplayClock is a Clock driven by the timestamps of replayed log lines. Its
+// tickers fire when Advance moves the clock past their deadline, so that each
+// tick is delivered before any line which happened after it.
+type ReplayClock struct {
+	// Speed is the replay speed multiplier, zero replays as fast as possible.
+	Speed float64
+
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*replayTicker
+	started chan bool
+	stopped chan bool
+	stop    sync.Once
+}
+
+type replayTicker struct {
+	c    chan time.Time
+	d    time.Duration
+	next time.Time
+}
+
+// NewReplayClock returns a ReplayClock replaying at the given speed.
+func NewReplayClock(speed float64) *ReplayClock {
+	return &ReplayClock{
+		Speed:   speed,
+		started: make(chan bool),
+		stopped: make(chan bool),
+	}
+}
+
+// ParseReplaySpeed parses replay speeds such as "1x", "10x", "2.5" or "max".
+func ParseReplaySpeed(speed string) (float64, error) {
+	if speed == "max" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(speed, "x"), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q, expected something like 1x, 10x or max", speed)
+	}
+	return value, nil
+}
+
+// Now implements the Clock interface.
+func (rc *ReplayClock) Now() time.Time {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.now
+}
+
+// Tick implements the Clock interface.
+func (rc *ReplayClock) Tick(d time.Duration) <-chan time.Time {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	t := &replayTicker{c: make(chan time.Time), d: d}
+	if !rc.now.IsZero() {
+		t.next = rc.now.Add(d)
+	}
+	rc.tickers = append(rc.tickers, t)
+	return t.c
+}
+
+// Start lets Advance run, once every ticker has been created.
+func (rc *ReplayClock) Start() {
+	close(rc.started)
+}
+
+// Stop makes Advance return at once, when the tickers are no longer read.
+func (rc *ReplayClock) Stop() {
+	rc.stop.Do(func() { close(rc.stopped) })
+}
+
+// Advance moves the clock forward to t, firing every ticker deadline on the
+// way and sleeping between them according to the replay speed. Advance never
+// moves the clock backwards.
+func (rc *ReplayClock) Advance(t time.Time) {
+	select {
+	case <-rc.started:
+	case <-rc.stopped:
+		return
+	}
+
+	rc.mu.Lock()
+	if rc.now.IsZero() {
+		rc.now = t
+		for _, ticker := range rc.tickers {
+			ticker.next = t.Add(ticker.d)
+		}
+	}
+
+	for {
+		var ticker *replayTicker
+		for _, candidate := range rc.tickers {
+			if candidate.next.After(t) {
+				continue
+			}
+			// On equal deadlines the shorter period fires first, so that
+			// the last refresh of an alert window comes before the alert.
+			if ticker == nil || candidate.next.Before(ticker.next) ||
+				candidate.next.Equal(ticker.next) && candidate.d < ticker.d {
+				ticker = candidate
+			}
+		}
+		if ticker == nil {
+			break
+		}
+
+		deadline := ticker.next
+		ticker.next = deadline.Add(ticker.d)
+		rc.mu.Unlock()
+		rc.sleep(deadline)
+
+		rc.mu.Lock()
+		rc.now = deadline
+		rc.mu.Unlock()
+		select {
+		case ticker.c <- deadline:
+		case <-rc.stopped:
+			return
+		}
+		rc.mu.Lock()
+	}
+	rc.mu.Unlock()
+
+	rc.sleep(t)
+	rc.mu.Lock()
+	if t.After(rc.now) {
+		rc.now = t
+	}
+	rc.mu.Unlock()
+}
+
+func (rc *ReplayClock) sleep(until time.Time) {
+	if rc.Speed <= 0 {
+		return
+	}
+	if d := until.Sub(rc.Now()); d > 0 {
+		time.Sleep(time.Duration(float64(d) / rc.Speed))
+	}
+}
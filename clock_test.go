@@ -0,0 +1,69 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClockSuite struct{}
+
+var _ = Suite(&ClockSuite{})
+
+func (s *ClockSuite) TestParseReplaySpeed(c *C) {
+	for speed, expected := range map[string]float64{"1x": 1, "10x": 10, "2.5": 2.5, "max": 0} {
+		value, err := ParseReplaySpeed(speed)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, expected)
+	}
+	for _, speed := range []string{"", "fast", "-1x", "0"} {
+		_, err := ParseReplaySpeed(speed)
+		c.Assert(err, ErrorMatches, "invalid replay speed .*")
+	}
+}
+
+func (s *ClockSuite) TestReplayClockTicks(c *C) {
+	rc := NewReplayClock(0)
+	alertC := rc.Tick(20 * time.Second)
+	refreshC := rc.Tick(10 * time.Second)
+	rc.Start()
+
+	start := time.Date(2016, 5, 11, 22, 0, 0, 0, time.UTC)
+	ticks := make(chan string, 10)
+	go func() {
+		rc.Advance(start)
+		rc.Advance(start.Add(5 * time.Second))
+		rc.Advance(start.Add(45 * time.Second))
+		close(ticks)
+	}()
+
+	got := make([]string, 0)
+loop:
+	for {
+		select {
+		case now := <-refreshC:
+			got = append(got, "refresh "+now.Sub(start).String())
+		case now := <-alertC:
+			got = append(got, "alert "+now.Sub(start).String())
+		case <-ticks:
+			break loop
+		}
+	}
+
+	c.Assert(got, DeepEquals, []string{"refresh 10s", "refresh 20s", "alert 20s", "refresh 30s", "refresh 40s", "alert 40s"})
+	c.Assert(rc.Now(), Equals, start.Add(45*time.Second))
+
+	// The clock never goes backwards.
+	rc.Advance(start)
+	c.Assert(rc.Now(), Equals, start.Add(45*time.Second))
+}
+
+func (s *ClockSuite) TestReplayClockSpeed(c *C) {
+	rc := NewReplayClock(100)
+	rc.Start()
+
+	start := time.Now()
+	rc.Advance(start)
+	rc.Advance(start.Add(20 * time.Second))
+	c.Assert(time.Since(start) >= 200*time.Millisecond, Equals, true)
+}
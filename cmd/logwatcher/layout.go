@@ -33,8 +33,8 @@ func Layout(g *gocui.Gui) error {
 		statsTotalV.BgColor = gocui.ColorDefault
 		statsTotalV.Title = fmt.Sprintf(" Stats Total | Every %d s ", config.RefreshInterval)
 		fmt.Fprintf(statsTotalV,
-			"%sTotal Hits : 0\n%sTotal 2XX  : 0\n%sTotal 3XX  : 0\n%sTotal 4XX  : 0\n%sTotal 5XX  : 0\n\n",
-			margin, margin, margin, margin, margin)
+			"%sTotal Hits : 0\n%sTotal 2XX  : 0\n%sTotal 3XX  : 0\n%sTotal 4XX  : 0\n%sTotal 5XX  : 0\n%sTotal Late : 0\n\n",
+			margin, margin, margin, margin, margin, margin)
 
 	}
 
@@ -46,7 +46,7 @@ func Layout(g *gocui.Gui) error {
 		statsAvgV.Frame = true
 		statsAvgV.Autoscroll = false
 		statsAvgV.BgColor = gocui.ColorDefault
-		statsAvgV.Title = fmt.Sprintf(" Stats Average | Every %d s ", config.AlertInterval)
+		statsAvgV.Title = fmt.Sprintf(" Stats Average | Past %d s ", config.AlertInterval)
 		fmt.Fprintf(statsAvgV,
 			"%sAvg Hits : 0\n%sAvg 2XX  : 0\n%sAvg 3XX  : 0\n%sAvg 4XX  : 0\n%sAvg 5XX  : 0\n\n",
 			margin, margin, margin, margin, margin)
@@ -54,7 +54,7 @@ func Layout(g *gocui.Gui) error {
 	}
 
 	if logTailV, err := g.SetView("log_tail",
-		0, maxY/4+maxY/8, maxX-1, maxY/2+maxY/8); err != nil {
+		0, maxY/4+maxY/8, maxX/2+maxX/4-1, maxY/2+maxY/8); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -66,8 +66,20 @@ func Layout(g *gocui.Gui) error {
 
 	}
 
+	if parseErrorsV, err := g.SetView("parse_errors",
+		maxX/2+maxX/4-1, maxY/4+maxY/8, maxX-1, maxY/2+maxY/8); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		parseErrorsV.Frame = true
+		parseErrorsV.Autoscroll = true
+		parseErrorsV.BgColor = gocui.ColorDefault
+		parseErrorsV.Title = fmt.Sprintf(" Parse Errors | Every %d s ", config.RefreshInterval)
+		fmt.Fprintf(parseErrorsV, "%sTotal Errors : 0\n\n", margin)
+	}
+
 	if topSectionsV, err := g.SetView("top_sections",
-		0, maxY/2+maxY/8, maxX/2-1, maxY-maxY/8); err != nil {
+		0, maxY/2+maxY/8, maxX/4-1, maxY-maxY/8); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -79,7 +91,7 @@ func Layout(g *gocui.Gui) error {
 	}
 
 	if topStatusV, err := g.SetView("top_status",
-		maxX/2-1, maxY/2+maxY/8, maxX-1, maxY-maxY/8); err != nil {
+		maxX/4-1, maxY/2+maxY/8, maxX/2-1, maxY-maxY/8); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -91,6 +103,30 @@ func Layout(g *gocui.Gui) error {
 
 	}
 
+	if topReferrersV, err := g.SetView("top_referrers",
+		maxX/2-1, maxY/2+maxY/8, maxX/2+maxX/4-1, maxY-maxY/8); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		topReferrersV.Frame = true
+		topReferrersV.Autoscroll = false
+		topReferrersV.BgColor = gocui.ColorDefault
+		topReferrersV.Title = fmt.Sprintf(" Top Referrers | Every %d s ", config.RefreshInterval)
+		fmt.Fprintf(topReferrersV, "%sTop Referrers:\n\n", margin)
+	}
+
+	if topUserAgentsV, err := g.SetView("top_user_agents",
+		maxX/2+maxX/4-1, maxY/2+maxY/8, maxX-1, maxY-maxY/8); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		topUserAgentsV.Frame = true
+		topUserAgentsV.Autoscroll = false
+		topUserAgentsV.BgColor = gocui.ColorDefault
+		topUserAgentsV.Title = fmt.Sprintf(" Top User Agents | Every %d s ", config.RefreshInterval)
+		fmt.Fprintf(topUserAgentsV, "%sTop User Agents:\n\n", margin)
+	}
+
 	if alertV, err := g.SetView("alert",
 		0, maxY-maxY/8, maxX-1, maxY-1); err != nil {
 		if err != gocui.ErrUnknownView {
@@ -99,7 +135,7 @@ func Layout(g *gocui.Gui) error {
 		alertV.Frame = true
 		alertV.Autoscroll = true
 		alertV.BgColor = gocui.ColorDefault
-		alertV.Title = fmt.Sprintf(" Alerting | Every %d s | Alert Threshold : %d",
+		alertV.Title = fmt.Sprintf(" Alerting | Past %d s | Alert Threshold : %d",
 			config.AlertInterval, config.AlertThreshold)
 		fmt.Fprintf(alertV, "%sNo alert for now (%s)\n\n", margin, time.Now().Format(time.StampMilli))
 
@@ -107,11 +143,13 @@ func Layout(g *gocui.Gui) error {
 	return nil
 }
 
-func Keybindings(g *gocui.Gui) error {
-	return g.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, Quit)
+func Keybindings(g *gocui.Gui, cs *console) error {
+	if err := g.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, Quit); err != nil {
+		return err
+	}
+	return g.SetKeybinding("", gocui.KeyTab, gocui.ModNone, cs.SwitchSource)
 }
 
 func Quit(g *gocui.Gui, v *gocui.View) error {
-	done <- true
 	return gocui.ErrQuit
 }
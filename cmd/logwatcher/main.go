@@ -0,0 +1,113 @@
+// Command logwatcher shows the statistics and alerts of HTTP access logs in
+// the terminal, or writes a batch report of them.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/jroimartin/gocui"
+	"github.com/rustx/logwatcher"
+)
+
+var config logwatcher.Config
+
+func main() {
+
+	parser := flags.NewParser(&config, flags.Default)
+	args, err := parser.Parse()
+	if err != nil {
+		fmt.Printf("Default :\n")
+		fmt.Printf("logwatcher --log-file /var/log/nginx/access.log --refresh-interval 10")
+		fmt.Printf("--alert-interval 120 --alert-threshold 400\n")
+		os.Exit(1)
+	}
+
+	// --stdin reads the log from standard input, on top of any --log-file
+	// given. The console still reads the keyboard from the terminal.
+	if config.Stdin {
+		if parser.FindOptionByLongName("log-file").IsSetDefault() {
+			config.LogFile = nil
+		}
+		config.LogFile = append(config.LogFile, logwatcher.StdinLogFile)
+	}
+	// Syslog listeners replace the default log file.
+	if len(config.SyslogListen) > 0 && !config.Stdin && parser.FindOptionByLongName("log-file").IsSetDefault() {
+		config.LogFile = nil
+	}
+	if config.StateFile != "" && (config.Replay || config.Report != "") {
+		fmt.Printf("Please review your options: --state-file can not be used to replay or report\nTry logwatcher -h\n")
+		os.Exit(1)
+	}
+	if len(config.SyslogListen) > 0 && (config.Replay || config.Report != "") {
+		fmt.Printf("Please review your options: --syslog-listen can not be replayed or reported\nTry logwatcher -h\n")
+		os.Exit(1)
+	}
+
+	lw, err := logwatcher.New(&config)
+	if err != nil {
+		fmt.Printf("Please review your options: %s\nTry logwatcher -h\n", err)
+		os.Exit(1)
+	}
+
+	// Batch reports read whole files given as arguments and exit.
+	if config.Report != "" {
+		files := args
+		if len(files) == 0 {
+			if files, err = logwatcher.ExpandLogFiles(config.LogFile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		report, err := lw.Report(files)
+		if err == nil {
+			err = report.Write(os.Stdout, config.Report)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logWriter, err := syslog.New(syslog.LOG_NOTICE, "logwatcher")
+	if err == nil {
+		log.SetOutput(logWriter)
+	}
+
+	g, err := gocui.NewGui(gocui.OutputNormal)
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+
+	defer g.Close()
+
+	cs := newConsole(lw, g)
+	g.SetManagerFunc(Layout)
+	if err := Keybindings(g, cs); err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+
+	lw.AddSink(cs)
+	if err := lw.Start(context.Background()); err != nil {
+		g.Close()
+		fmt.Printf("Please review your options: %s\nTry logwatcher -h\n", err)
+		os.Exit(1)
+	}
+	done := make(chan bool)
+	go cs.Run(g, done)
+
+	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+
+	close(done)
+	lw.Stop()
+}
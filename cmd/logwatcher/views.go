@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jroimartin/gocui"
+	"github.com/rustx/logwatcher"
+)
+
+var (
+	margin = "\t\n\t\t\t\t\t"
+	tab    = "\t\t\t\t\t"
+
+	maxTailLines = 100
+)
+
+// console is the Sink drawing the statistics and alerts of a Watcher in the
+// terminal.
+type console struct {
+	lw  *logwatcher.Watcher
+	cfg *logwatcher.Config
+	g   *gocui.Gui
+
+	mu           sync.Mutex
+	snap         logwatcher.Snapshot
+	lines        []string
+	recovered    bool
+	sourceFilter string
+}
+
+func newConsole(lw *logwatcher.Watcher, g *gocui.Gui) *console {
+	cs := &console{
+		lw:    lw,
+		cfg:   lw.Config,
+		g:     g,
+		snap:  lw.Snapshot(),
+		lines: make([]string, 0),
+	}
+	return cs
+}
+
+// Line keeps line for the log tail view.
+func (cs *console) Line(line string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.lines = append(cs.lines, line)
+	if len(cs.lines) > maxTailLines {
+		cs.lines = cs.lines[len(cs.lines)-maxTailLines:]
+	}
+}
+
+// Refresh redraws the views of the refresh window statistics.
+func (cs *console) Refresh(snap logwatcher.Snapshot) {
+	cs.mu.Lock()
+	cs.snap = snap
+	cs.mu.Unlock()
+
+	cs.UpdateStatsTotalView(cs.g)
+	cs.UpdateTopSectionsView(cs.g)
+	cs.UpdateTopStatusView(cs.g)
+	cs.UpdateTopReferrersView(cs.g)
+	cs.UpdateTopUserAgentsView(cs.g)
+	cs.UpdateParseErrorsView(cs.g)
+}
+
+// Alert keeps whether the last alert recovered, to color the alert view.
+func (cs *console) Alert(alert logwatcher.Alert) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.recovered = alert.Recovered
+}
+
+// Average redraws the views of the alert window statistics.
+func (cs *console) Average(snap logwatcher.Snapshot) {
+	cs.mu.Lock()
+	cs.snap = snap
+	recovered := cs.recovered
+	cs.mu.Unlock()
+
+	cs.UpdateAlertView(cs.g, recovered)
+	cs.UpdateStatsAvgView(cs.g)
+}
+
+// Run redraws the views following the clock and the log tail until done.
+func (cs *console) Run(g *gocui.Gui, done <-chan bool) {
+	mainTicker := time.NewTicker(time.Duration(1) * time.Second)
+	logTicker := time.NewTicker(time.Duration(cs.cfg.LogInterval) * time.Millisecond)
+	defer mainTicker.Stop()
+	defer logTicker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-logTicker.C:
+			cs.mu.Lock()
+			lines := append([]string(nil), cs.lines...)
+			cs.mu.Unlock()
+			cs.UpdateLogTailView(g, lines)
+		case <-mainTicker.C:
+			cs.UpdateMainView(g)
+		}
+	}
+}
+
+// sourceNames returns the sorted names of the log sources seen so far.
+func (cs *console) sourceNames() []string {
+	names := make([]string, 0, len(cs.snap.Sources))
+	for name := range cs.snap.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectedStats returns the totals of the source selected in the console, or
+// the totals of all sources when none is.
+func (cs *console) selectedStats() logwatcher.StatsTotal {
+	if stats, ok := cs.snap.Sources[cs.sourceFilter]; ok && cs.sourceFilter != "" {
+		return stats
+	}
+	return cs.snap.Total
+}
+
+// nextSource selects the next log source, cycling back to all sources.
+func (cs *console) nextSource() {
+	names := cs.sourceNames()
+	i := sort.SearchStrings(names, cs.sourceFilter)
+
+	switch {
+	case cs.sourceFilter == "" && len(names) > 0:
+		cs.sourceFilter = names[0]
+	case i+1 < len(names) && names[i] == cs.sourceFilter:
+		cs.sourceFilter = names[i+1]
+	default:
+		cs.sourceFilter = ""
+	}
+}
+
+// sourceName returns the name of the log source selected in the console.
+func (cs *console) sourceName() string {
+	if cs.sourceFilter == "" {
+		return "all"
+	}
+	return cs.sourceFilter
+}
+
+// SwitchSource is the keybinding handler selecting the next log source, and
+// redrawing the views showing per source statistics.
+func (cs *console) SwitchSource(g *gocui.Gui, v *gocui.View) error {
+	cs.mu.Lock()
+	cs.nextSource()
+	cs.mu.Unlock()
+
+	cs.UpdateMainView(g)
+	cs.UpdateStatsTotalView(g)
+	cs.UpdateTopSectionsView(g)
+	cs.UpdateTopStatusView(g)
+	cs.UpdateTopReferrersView(g)
+	cs.UpdateTopUserAgentsView(g)
+	return nil
+}
+
+func sortMap(m map[string]int) (msg string) {
+
+	n := map[int][]string{}
+	a := make([]int, 0)
+	msg = margin + tab
+
+	for k, v := range m {
+		n[v] = append(n[v], k)
+	}
+	for k := range n {
+		a = append(a, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(a)))
+	for _, k := range a {
+		for _, s := range n[k] {
+			msg += fmt.Sprintf("%s%s%s : %d", margin, tab, s, k)
+		}
+	}
+	return msg
+}
+
+func (cs *console) UpdateMainView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		mainV, err := g.View("main")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		mainV.Clear()
+		fmt.Fprintf(mainV,
+			"%sDate Now: %s\n%sTime Elapsed : %s\n\n%s"+
+				"Refresh Interval : %d s%sAlert Interval : %d s%sLog Interval : %d ms%sAlert Threshold : %d\n%s"+
+				"Source : %s (%d sources, Tab to switch)%sDropped Lines : %d\n",
+			margin, cs.lw.Date(), tab, cs.lw.TimeElapsed(), tab,
+			cs.cfg.RefreshInterval, tab, cs.cfg.AlertInterval, tab, cs.cfg.LogInterval, tab, cs.cfg.AlertThreshold, tab,
+			cs.sourceName(), len(cs.snap.Sources), tab, cs.lw.Dropped())
+		return nil
+
+	})
+	return nil
+}
+
+func (cs *console) UpdateStatsTotalView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		statsTotalV, err := g.View("stats_total")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		st := cs.selectedStats()
+		statsTotalV.Clear()
+		statsTotalV.Title = fmt.Sprintf(" Stats Total | Every %d s | Source : %s ", cs.cfg.RefreshInterval, cs.sourceName())
+		fmt.Fprintf(statsTotalV,
+			"%sTotal Hits : %d\n%sTotal 2XX  : %v\n%sTotal 3XX  : %d\n%sTotal 4XX  : %d\n%sTotal 5XX  : %d\n%sTotal Late : %d\n\n",
+			margin, st.TotalHits, margin, st.Total2xx, margin, st.Total3xx, margin, st.Total4xx, margin, st.Total5xx,
+			margin, st.TotalLate)
+		return nil
+
+	})
+	return nil
+}
+
+func (cs *console) UpdateStatsAvgView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		statsAvgV, err := g.View("stats_avg")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		avg := cs.snap.Avg
+		statsAvgV.Clear()
+		fmt.Fprintf(statsAvgV,
+			"%sAvg Hits : %d\n%sAvg 2XX  : %d\n%sAvg 3XX  : %d\n%sAvg 4XX  : %d\n%sAvg 5XX  : %d\n\n",
+			margin, avg.AvgHits, margin, avg.Avg2xx, margin, avg.Avg3xx, margin, avg.Avg4xx, margin, avg.Avg5xx)
+		return nil
+
+	})
+	return nil
+}
+
+func (cs *console) UpdateLogTailView(g *gocui.Gui, logEvents []string) error {
+	g.Update(func(g *gocui.Gui) error {
+		logTailV, err := g.View("log_tail")
+		if err != nil {
+			return err
+		}
+		logTailV.Clear()
+		for _, line := range logEvents {
+			fmt.Fprintf(logTailV, "%s%s", margin, line)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (cs *console) UpdateTopSectionsView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		topSectionsV, err := g.View("top_sections")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		topSectionsV.Clear()
+		fmt.Fprintf(topSectionsV, "%sTop Sections :\n%v%s",
+			margin, sortMap(cs.selectedStats().TopSections), margin)
+		return nil
+
+	})
+	return nil
+}
+
+func (cs *console) UpdateTopStatusView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		topStatusV, err := g.View("top_status")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		topStatusV.Clear()
+		fmt.Fprintf(topStatusV, "%sTop Status :\n%v%s",
+			margin, sortMap(cs.selectedStats().TopStatus), margin)
+		return nil
+	})
+	return nil
+}
+
+func (cs *console) UpdateTopReferrersView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		topReferrersV, err := g.View("top_referrers")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		topReferrersV.Clear()
+		fmt.Fprintf(topReferrersV, "%sTop Referrers :\n%v%s",
+			margin, sortMap(cs.selectedStats().TopReferrers), margin)
+		return nil
+	})
+	return nil
+}
+
+func (cs *console) UpdateTopUserAgentsView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		topUserAgentsV, err := g.View("top_user_agents")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		topUserAgentsV.Clear()
+		fmt.Fprintf(topUserAgentsV, "%sTop User Agents :\n%v%s",
+			margin, sortMap(cs.selectedStats().TopUserAgents), margin)
+		return nil
+	})
+	return nil
+}
+
+func (cs *console) UpdateParseErrorsView(g *gocui.Gui) error {
+	g.Update(func(g *gocui.Gui) error {
+		parseErrorsV, err := g.View("parse_errors")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		parseErrorsV.Clear()
+		fmt.Fprintf(parseErrorsV, "%sTotal Errors : %d\n%v%s",
+			margin, cs.snap.Errors.TotalErrors, sortMap(cs.snap.Errors.ErrorReasons), margin)
+		for _, line := range cs.snap.Errors.ErrorSamples {
+			fmt.Fprintf(parseErrorsV, "%s%s", margin, line)
+		}
+		return nil
+	})
+	return nil
+}
+
+// firingRules returns the number of alert rules firing.
+func firingRules(rules []logwatcher.RuleState) int {
+	firing := 0
+	for _, rule := range rules {
+		if rule.Firing {
+			firing++
+		}
+	}
+	return firing
+}
+
+// unsettled tells whether an alert is pending or flapping.
+func unsettled(snap logwatcher.Snapshot) bool {
+	if snap.AlertPending || snap.AlertFlapping {
+		return true
+	}
+	for _, rule := range snap.Rules {
+		if rule.Pending || rule.Flapping {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *console) UpdateAlertView(g *gocui.Gui, recovered bool) error {
+	g.Update(func(g *gocui.Gui) error {
+		alertV, err := g.View("alert")
+		if err != nil {
+			return err
+		}
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		alertV.Clear()
+		switch {
+		case cs.snap.AlertState || firingRules(cs.snap.Rules) > 0:
+			alertV.BgColor = gocui.ColorRed
+		case unsettled(cs.snap):
+			alertV.BgColor = gocui.ColorYellow
+		case recovered:
+			alertV.BgColor = gocui.ColorGreen
+		default:
+			alertV.BgColor = gocui.ColorDefault
+		}
+		switch {
+		case cs.snap.AlertFlapping:
+			fmt.Fprintf(alertV, "%sHigh traffic alert : flapping", margin)
+		case cs.snap.AlertPending:
+			fmt.Fprintf(alertV, "%sHigh traffic alert : pending", margin)
+		}
+		for _, msg := range cs.snap.AlertMsg {
+			fmt.Fprintf(alertV, "%s%s", margin, msg)
+		}
+		for _, rule := range cs.snap.Rules {
+			state := "ok"
+			switch {
+			case rule.Firing:
+				state = "FIRING since " + rule.Since.Format(time.Stamp)
+			case rule.Pending:
+				state = "pending"
+			}
+			if rule.Flapping {
+				state += ", flapping"
+			}
+			if rule.Expr == "" {
+				state += fmt.Sprintf(" (%g)", rule.Value)
+			}
+			fmt.Fprintf(alertV, "%s%s [%s] : %s", margin, rule.Name, rule.Severity, state)
+			for _, msg := range rule.History {
+				fmt.Fprintf(alertV, "%s%s%s", margin, tab, msg)
+			}
+		}
+		return nil
+	})
+	return nil
+}
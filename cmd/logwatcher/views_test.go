@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rustx/logwatcher"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ConsoleSuite struct{}
+
+var _ = Suite(&ConsoleSuite{})
+
+func (s *ConsoleSuite) TestSortMap(c *C) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	score := make(map[string]int)
+
+	for i := 0; i < 100; i++ {
+		score["200"] = r.Intn(100)
+		score["300"] = r.Intn(200)
+		score["400"] = r.Intn(900)
+		score["500"] = r.Intn(500)
+		result := sortMap(score)
+
+		c.Log(result)
+		c.Assert(result, Not(IsNil))
+	}
+
+	c.Assert(sortMap(map[string]int{"/a": 1, "/b": 3}), Equals,
+		margin+tab+margin+tab+"/b : 3"+margin+tab+"/a : 1")
+}
+
+func (s *ConsoleSuite) TestNextSource(c *C) {
+	cs := &console{snap: logwatcher.Snapshot{
+		Total: logwatcher.StatsTotal{TotalHits: 3},
+		Sources: map[string]logwatcher.StatsTotal{
+			"a.log": {TotalHits: 1},
+			"b.log": {TotalHits: 2},
+		},
+	}}
+
+	c.Assert(cs.sourceName(), Equals, "all")
+	c.Assert(cs.selectedStats().TotalHits, Equals, 3)
+	cs.nextSource()
+	c.Assert(cs.sourceFilter, Equals, "a.log")
+	c.Assert(cs.selectedStats().TotalHits, Equals, 1)
+	cs.nextSource()
+	c.Assert(cs.sourceName(), Equals, "b.log")
+	c.Assert(cs.selectedStats().TotalHits, Equals, 2)
+	cs.nextSource()
+	c.Assert(cs.sourceFilter, Equals, "")
+}
@@ -1,12 +1,45 @@
-package main
+package logwatcher
 
 // Config structs contains the arguments given by go-flags from the command line.
 type Config struct {
-	RefreshInterval int    `long:"refresh-interval" default:"10"`
-	AlertInterval   int    `long:"alert-interval" default:"120"`
-	AlertThreshold  int    `long:"alert-threshold" default:"400"`
-	LogInterval     int    `long:"log-interval" default:"500"`
-	LogFile         string `long:"log-file" default:"/var/log/nginx/access.log"`
+	RefreshInterval       int               `long:"refresh-interval" default:"10"`
+	AlertInterval         int               `long:"alert-interval" default:"120"`
+	AlertThreshold        int               `long:"alert-threshold" default:"400"`
+	LogInterval           int               `long:"log-interval" default:"500"`
+	LogFile               []string          `long:"log-file" default:"/var/log/nginx/access.log"`
+	GlobInterval          int               `long:"glob-interval" default:"10"`
+	Stdin                 bool              `long:"stdin"`
+	SyslogListen          []string          `long:"syslog-listen"`
+	LogFormat             string            `long:"log-format" default:"clf"`
+	LogPattern            string            `long:"log-pattern"`
+	JSONFields            map[string]string `long:"json-field"`
+	DeadLetterFile        string            `long:"dead-letter-file"`
+	AllowedLateness       int               `long:"allowed-lateness" default:"0"`
+	Replay                bool              `long:"replay"`
+	ReplaySpeed           string            `long:"replay-speed" default:"1x"`
+	Report                string            `long:"report" choice:"text" choice:"json" choice:"csv"`
+	StateFile             string            `long:"state-file"`
+	StateInterval         int               `long:"state-interval" default:"10"`
+	QueueSize             int               `long:"queue-size" default:"1024"`
+	OverflowPolicy        string            `long:"overflow-policy" choice:"block" choice:"drop-oldest" choice:"sample" default:"block"`
+	SampleRate            int               `long:"sample-rate" default:"10"`
+	ParserWorkers         int               `long:"parser-workers" default:"1"`
+	ParserEngine          string            `long:"parser-engine" choice:"regexp" choice:"scan" default:"regexp"`
+	AlertConfig           string            `long:"alert-config"`
+	AlertFor              int               `long:"alert-for" default:"0"`
+	AlertRecoverThreshold int               `long:"alert-recover-threshold"`
+	FlapWindow            int               `long:"flap-window" default:"0"`
+	FlapThreshold         int               `long:"flap-threshold" default:"4"`
+	NotifyWebhook         []string          `long:"notify-webhook"`
+	NotifySlack           []string          `long:"notify-slack"`
+	NotifySlackTemplate   string            `long:"notify-slack-template"`
+	NotifyPagerDuty       string            `long:"notify-pagerduty"`
+	NotifyPagerDutyURL    string            `long:"notify-pagerduty-url" default:"https://events.pagerduty.com/v2/enqueue"`
+	NotifyExec            []string          `long:"notify-exec"`
+	NotifySMTP            string            `long:"notify-smtp"`
+	NotifySMTPFrom        string            `long:"notify-smtp-from"`
+	NotifySMTPTo          []string          `long:"notify-smtp-to"`
+	NotifySMTPUser        string            `long:"notify-smtp-user"`
+	NotifyTimeout         int               `long:"notify-timeout" default:"10"`
+	NotifyRetries         int               `long:"notify-retries" default:"3"`
 }
-
-var config Config
@@ -0,0 +1,585 @@
+package logwatcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a condition of an alert rule, like
+//
+//	rate(status_5xx) / rate(hits) > 0.05 and hits > 100
+//
+// evaluated on the StatItem of the rule window. Its metrics are hits, bytes
+// and status_2xx to status_5xx, which are numbers, and sections, status,
+// referrers and user_agents, which are count maps by key. Its functions are
+//
+//	rate(number)              the number per second of the window
+//	total(counts)             the sum of the counts
+//	count(counts, "key")      the count of key
+//	share(counts, "key")      the count of key over the sum of the counts
+//	topk_share(counts, k)     the k largest counts over the sum of the counts
+//
+// Numbers combine with + - * /, a division by zero giving zero, and compare
+// with > >= < <= == !=. Conditions combine with and, or and not.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ExprError is an error of an expression, at a column counted from 1.
+type ExprError struct {
+	Column int
+	Msg    string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// ParseExpr parses and type checks a condition.
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{lex: exprLexer{src: src}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	typ, err := root.check()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeBool {
+		return nil, &ExprError{Column: 1, Msg: "expression is a " + typ.String() + ", not a condition"}
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval tells whether the condition holds for the item of a window of the
+// given length.
+func (e *Expr) Eval(item *StatItem, window time.Duration) bool {
+	return e.root.eval(&exprEnv{item: item, window: window}).b
+}
+
+type exprType int
+
+const (
+	typeNumber exprType = iota
+	typeBool
+	typeCounts
+	typeString
+)
+
+func (t exprType) String() string {
+	return [...]string{"number", "condition", "count map", "string"}[t]
+}
+
+// exprValue is the value of an expression node, of the type it checked to.
+type exprValue struct {
+	num    float64
+	b      bool
+	counts map[string]int
+	str    string
+}
+
+type exprEnv struct {
+	item   *StatItem
+	window time.Duration
+}
+
+type exprNode interface {
+	column() int
+	check() (exprType, error)
+	eval(env *exprEnv) exprValue
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int
+}
+
+type exprLexer struct {
+	src string
+	pos int
+}
+
+func (l *exprLexer) next() (token, error) {
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	col := start + 1
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, col: col}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case isDigit(c) || c == '.':
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], col: col}, nil
+	case c == '"':
+		end := strings.IndexByte(l.src[start+1:], '"')
+		if end < 0 {
+			return token{}, &ExprError{Column: col, Msg: "unterminated string"}
+		}
+		l.pos = start + end + 2
+		return token{kind: tokString, text: l.src[start+1 : start+1+end], col: col}, nil
+	case isIdentByte(c) && !isDigit(c):
+		for l.pos < len(l.src) && isIdentByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], col: col}, nil
+	}
+
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<", "+", "-", "*", "/", "(", ")", ","} {
+		if strings.HasPrefix(l.src[start:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, col: col}, nil
+		}
+	}
+	return token{}, &ExprError{Column: col, Msg: fmt.Sprintf("unexpected character %q", c)}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Parser
+
+type exprParser struct {
+	lex exprLexer
+	tok token
+}
+
+func (p *exprParser) next() error {
+	tok, err := p.lex.next()
+	p.tok = tok
+	return err
+}
+
+func (p *exprParser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return &ExprError{Column: p.tok.col, Msg: "unexpected end of expression"}
+	}
+	return &ExprError{Column: p.tok.col, Msg: fmt.Sprintf("unexpected %q", p.tok.text)}
+}
+
+// is tells whether the current token is the keyword or operator text.
+func (p *exprParser) is(text string) bool {
+	return (p.tok.kind == tokOp || p.tok.kind == tokIdent) && p.tok.text == text
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "or")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseNot, "and")
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if !p.is("not") {
+		return p.parseComparison()
+	}
+	tok := p.tok
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return &unaryNode{op: tok.text, col: tok.col, operand: operand}, nil
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ruleComparisons {
+		if p.is(op) {
+			tok := p.tok
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			right, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: tok.text, col: tok.col, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	return p.parseBinary(p.parseProduct, "+", "-")
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/")
+}
+
+// parseBinary parses the left associative operations of ops on the operands
+// parsed by operand.
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var tok token
+		for _, op := range ops {
+			if p.is(op) {
+				tok = p.tok
+			}
+		}
+		if tok.text == "" {
+			return left, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, col: tok.col, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if !p.is("-") {
+		return p.parsePrimary()
+	}
+	tok := p.tok
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &unaryNode{op: tok.text, col: tok.col, operand: operand}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &ExprError{Column: tok.col, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		return &literalNode{col: tok.col, typ: typeNumber, value: exprValue{num: value}}, p.next()
+	case tok.kind == tokString:
+		return &literalNode{col: tok.col, typ: typeString, value: exprValue{str: tok.text}}, p.next()
+	case tok.kind == tokIdent && !p.is("and") && !p.is("or") && !p.is("not"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.is("(") {
+			return &metricNode{name: tok.text, col: tok.col}, nil
+		}
+		return p.parseCall(tok)
+	case p.is("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.unexpected()
+		}
+		return node, p.next()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *exprParser) parseCall(name token) (exprNode, error) {
+	call := &callNode{name: name.text, col: name.col}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		if len(call.args) > 0 {
+			if !p.is(",") {
+				return nil, p.unexpected()
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	return call, p.next()
+}
+
+// Nodes
+
+type literalNode struct {
+	col   int
+	typ   exprType
+	value exprValue
+}
+
+func (n *literalNode) column() int                 { return n.col }
+func (n *literalNode) check() (exprType, error)    { return n.typ, nil }
+func (n *literalNode) eval(env *exprEnv) exprValue { return n.value }
+
+// exprMetrics are the metrics of a StatItem, with their types.
+var exprMetrics = map[string]exprType{
+	"hits": typeNumber, "bytes": typeNumber,
+	"status_2xx": typeNumber, "status_3xx": typeNumber, "status_4xx": typeNumber, "status_5xx": typeNumber,
+	"sections": typeCounts, "status": typeCounts, "referrers": typeCounts, "user_agents": typeCounts,
+}
+
+type metricNode struct {
+	name string
+	col  int
+}
+
+func (n *metricNode) column() int { return n.col }
+
+func (n *metricNode) check() (exprType, error) {
+	typ, ok := exprMetrics[n.name]
+	if !ok {
+		return 0, &ExprError{Column: n.col, Msg: fmt.Sprintf("unknown metric %q", n.name)}
+	}
+	return typ, nil
+}
+
+func (n *metricNode) eval(env *exprEnv) exprValue {
+	item := env.item
+	switch n.name {
+	case "hits":
+		return exprValue{num: float64(item.Hits)}
+	case "bytes":
+		return exprValue{num: float64(item.Bytes)}
+	case "status_2xx":
+		return exprValue{num: float64(item.Status2xx)}
+	case "status_3xx":
+		return exprValue{num: float64(item.Status3xx)}
+	case "status_4xx":
+		return exprValue{num: float64(item.Status4xx)}
+	case "status_5xx":
+		return exprValue{num: float64(item.Status5xx)}
+	case "sections":
+		return exprValue{counts: item.TopSections}
+	case "status":
+		return exprValue{counts: item.TopStatus}
+	case "referrers":
+		return exprValue{counts: item.TopReferrers}
+	}
+	return exprValue{counts: item.TopUserAgents}
+}
+
+type unaryNode struct {
+	op      string
+	col     int
+	operand exprNode
+}
+
+func (n *unaryNode) column() int { return n.col }
+
+func (n *unaryNode) check() (exprType, error) {
+	want := typeNumber
+	if n.op == "not" {
+		want = typeBool
+	}
+	if err := checkType(n.operand, want, "operand of "+n.op); err != nil {
+		return 0, err
+	}
+	return want, nil
+}
+
+func (n *unaryNode) eval(env *exprEnv) exprValue {
+	v := n.operand.eval(env)
+	if n.op == "not" {
+		return exprValue{b: !v.b}
+	}
+	return exprValue{num: -v.num}
+}
+
+type binaryNode struct {
+	op          string
+	col         int
+	left, right exprNode
+}
+
+func (n *binaryNode) column() int { return n.col }
+
+func (n *binaryNode) check() (exprType, error) {
+	operand, result := typeNumber, typeNumber
+	switch n.op {
+	case "and", "or":
+		operand, result = typeBool, typeBool
+	case ">", ">=", "<", "<=", "==", "!=":
+		result = typeBool
+	}
+	if err := checkType(n.left, operand, "left operand of "+n.op); err != nil {
+		return 0, err
+	}
+	if err := checkType(n.right, operand, "right operand of "+n.op); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func (n *binaryNode) eval(env *exprEnv) exprValue {
+	switch n.op {
+	case "and":
+		return exprValue{b: n.left.eval(env).b && n.right.eval(env).b}
+	case "or":
+		return exprValue{b: n.left.eval(env).b || n.right.eval(env).b}
+	}
+
+	left, right := n.left.eval(env).num, n.right.eval(env).num
+	switch n.op {
+	case "+":
+		return exprValue{num: left + right}
+	case "-":
+		return exprValue{num: left - right}
+	case "*":
+		return exprValue{num: left * right}
+	case "/":
+		if right == 0 {
+			return exprValue{}
+		}
+		return exprValue{num: left / right}
+	}
+	rule := Rule{Comparison: n.op, Threshold: right}
+	return exprValue{b: rule.compare(left)}
+}
+
+// exprFunc is a function of the expressions, with the types of its arguments.
+type exprFunc struct {
+	args []exprType
+	fn   func(env *exprEnv, args []exprValue) float64
+}
+
+var exprFuncs = map[string]exprFunc{
+	"rate": {[]exprType{typeNumber}, func(env *exprEnv, args []exprValue) float64 {
+		if env.window <= 0 {
+			return 0
+		}
+		return args[0].num / env.window.Seconds()
+	}},
+	"total": {[]exprType{typeCounts}, func(env *exprEnv, args []exprValue) float64 {
+		return float64(totalCount(args[0].counts))
+	}},
+	"count": {[]exprType{typeCounts, typeString}, func(env *exprEnv, args []exprValue) float64 {
+		return float64(args[0].counts[args[1].str])
+	}},
+	"share": {[]exprType{typeCounts, typeString}, func(env *exprEnv, args []exprValue) float64 {
+		return countShare(args[0].counts[args[1].str], args[0].counts)
+	}},
+	"topk_share": {[]exprType{typeCounts, typeNumber}, func(env *exprEnv, args []exprValue) float64 {
+		values := make([]int, 0, len(args[0].counts))
+		for _, v := range args[0].counts {
+			values = append(values, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(values)))
+		top := 0
+		for i := 0; i < len(values) && i < int(args[1].num); i++ {
+			top += values[i]
+		}
+		return countShare(top, args[0].counts)
+	}},
+}
+
+func totalCount(counts map[string]int) int {
+	total := 0
+	for _, v := range counts {
+		total += v
+	}
+	return total
+}
+
+// countShare returns value over the sum of counts, zero when there is none.
+func countShare(value int, counts map[string]int) float64 {
+	total := totalCount(counts)
+	if total == 0 {
+		return 0
+	}
+	return float64(value) / float64(total)
+}
+
+type callNode struct {
+	name string
+	col  int
+	args []exprNode
+}
+
+func (n *callNode) column() int { return n.col }
+
+func (n *callNode) check() (exprType, error) {
+	fn, ok := exprFuncs[n.name]
+	if !ok {
+		return 0, &ExprError{Column: n.col, Msg: fmt.Sprintf("unknown function %q", n.name)}
+	}
+	if len(n.args) != len(fn.args) {
+		return 0, &ExprError{Column: n.col, Msg: fmt.Sprintf("%s expects %d arguments, got %d", n.name, len(fn.args), len(n.args))}
+	}
+	for i, arg := range n.args {
+		if err := checkType(arg, fn.args[i], fmt.Sprintf("argument %d of %s", i+1, n.name)); err != nil {
+			return 0, err
+		}
+	}
+	return typeNumber, nil
+}
+
+func (n *callNode) eval(env *exprEnv) exprValue {
+	args := make([]exprValue, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(env)
+	}
+	return exprValue{num: exprFuncs[n.name].fn(env, args)}
+}
+
+// checkType checks node, and that it is of type want.
+func checkType(node exprNode, want exprType, what string) error {
+	typ, err := node.check()
+	if err != nil {
+		return err
+	}
+	if typ != want {
+		return &ExprError{Column: node.column(), Msg: fmt.Sprintf("%s must be a %s, got a %s", what, want, typ)}
+	}
+	return nil
+}
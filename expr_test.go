@@ -0,0 +1,75 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ExprSuite struct{}
+
+var _ = Suite(&ExprSuite{})
+
+func exprItem() *StatItem {
+	item := newStatItem()
+	for i := 0; i < 200; i++ {
+		event := &CommonLog{Request: "/pages/1", Status: 200, Bytes: 100, UserAgent: "curl"}
+		switch {
+		case i < 12:
+			event.Status = 503
+		case i < 20:
+			event.Status = 404
+		}
+		if i%4 == 0 {
+			event.Request = "/api/users"
+		}
+		item.add(event)
+	}
+	return item
+}
+
+func (s *ExprSuite) TestExprEval(c *C) {
+	item := exprItem()
+	for src, expected := range map[string]bool{
+		`rate(status_5xx) / rate(hits) > 0.05 and hits > 100`: true,
+		`rate(status_5xx) / rate(hits) > 0.1 or hits > 1000`:  false,
+		`topk_share(sections, 1) > 0.7`:                       true,
+		`topk_share(sections, 2) == 1`:                        true,
+		`share(sections, "/api") == 0.25`:                     true,
+		`count(status, "404") == 8 and total(status) == hits`: true,
+		`count(referrers, "-") == 0`:                          true,
+		`rate(bytes) == 200`:                                  true,
+		`not (status_4xx >= 8)`:                               false,
+		`-status_2xx + 2 * 90 < 0`:                            false,
+		`status_3xx / status_3xx == 0`:                        true,
+		`hits - 100 - 50 == 50`:                               true,
+	} {
+		expr, err := ParseExpr(src)
+		c.Assert(err, IsNil, Commentf("expression %s", src))
+		c.Assert(expr.Eval(item, 100*time.Second), Equals, expected, Commentf("expression %s", src))
+	}
+}
+
+func (s *ExprSuite) TestParseExprKo(c *C) {
+	for src, expected := range map[string]string{
+		`hits > 10 and hitz > 1`:      `column 15: unknown metric "hitz"`,
+		`rates(hits) > 1`:             `column 1: unknown function "rates"`,
+		`rate(hits, 2) > 1`:           `column 1: rate expects 1 arguments, got 2`,
+		`topk_share(hits, 1) > 0.8`:   `column 12: argument 1 of topk_share must be a count map, got a number`,
+		`share(sections, 1) > 0.8`:    `column 17: argument 2 of share must be a string, got a number`,
+		`hits > 10 and 5`:             `column 15: right operand of and must be a condition, got a number`,
+		`sections > 1`:                `column 1: left operand of > must be a number, got a count map`,
+		`not hits`:                    `column 5: operand of not must be a condition, got a number`,
+		`hits + 1`:                    `column 1: expression is a number, not a condition`,
+		`hits > 1 > 2`:                `column 10: unexpected ">"`,
+		`(hits > 1`:                   `column 10: unexpected end of expression`,
+		`hits > 1 and`:                `column 13: unexpected end of expression`,
+		`hits > 1 % 2`:                `column 10: unexpected character '%'`,
+		`count(status, "404) > 1`:     `column 15: unterminated string`,
+		`hits > 1.2.3`:                `column 8: invalid number "1.2.3"`,
+		`rate(hits) > 1 and (or > 1)`: `column 21: unexpected "or"`,
+	} {
+		_, err := ParseExpr(src)
+		c.Assert(err, ErrorMatches, expected, Commentf("expression %s", src))
+	}
+}
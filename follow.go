@@ -0,0 +1,355 @@
+package logwatcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// followInterval is how often followers look for new lines and rotations.
+var followInterval = 250 * time.Millisecond
+
+// FilePosition is where a line ends in a log file, identified by its inode so
+// that a rotation is told apart from a truncation.
+type FilePosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// follower reads the lines of a log file and follows it across rotations.
+//
+// A rename rotation is detected when the path no longer holds the inode being
+// read. The renamed file is still read through its open descriptor, even once
+// logrotate compressed and removed it, until the writer switches to the new
+// file, so that no line is lost. A copytruncate rotation is detected when the
+// file shrinks, and the lines missed before the truncation are read back from
+// the rotated copy, compressed or not.
+type follower struct {
+	path       string
+	follow     bool
+	directives bool
+	lines      chan Line
+	done       <-chan struct{}
+
+	file   *os.File
+	reader *bufio.Reader
+	pos    FilePosition
+
+	rotated    *os.File
+	rotatedPos FilePosition
+	partial    string
+	missed     bool
+	header     []string
+}
+
+// openFollower opens path, at its end unless fromStart is set. Followers
+// which do not follow stop at the end of the file.
+func openFollower(path string, fromStart, follow bool) (*follower, error) {
+	fl := &follower{
+		path:   path,
+		follow: follow,
+		lines:  make(chan Line),
+	}
+	if err := fl.open(); err != nil {
+		return nil, err
+	}
+	if !fromStart {
+		offset, err := fl.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			fl.file.Close()
+			return nil, err
+		}
+		fl.pos.Offset = offset
+	}
+	return fl, nil
+}
+
+// resumeFollower opens path at a position saved by an earlier run. When the
+// file was rotated since, the lines after that position are read back from
+// the rotated copy before the new file is read from its start. With
+// directives set, the directive lines before the position, like the W3C
+// #Fields, are sent again first, without a position.
+func resumeFollower(path string, pos FilePosition, follow, directives bool) (*follower, error) {
+	fl, err := openFollower(path, true, follow)
+	if err != nil {
+		return nil, err
+	}
+	fl.directives = directives
+
+	info, err := fl.file.Stat()
+	if err != nil {
+		fl.file.Close()
+		return nil, err
+	}
+	if fl.pos.Inode != pos.Inode || info.Size() < pos.Offset {
+		fl.pos = pos
+		fl.missed = true
+		return fl, nil
+	}
+	if directives {
+		fl.header = readDirectives(fl.file, pos.Offset)
+	}
+	if _, err := fl.file.Seek(pos.Offset, io.SeekStart); err != nil {
+		fl.file.Close()
+		return nil, err
+	}
+	fl.pos = pos
+	return fl, nil
+}
+
+func (fl *follower) open() error {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fl.file = f
+	fl.pos = FilePosition{Inode: inode(info)}
+	fl.reader = bufio.NewReaderSize(f, 64*1024)
+	return nil
+}
+
+// inode returns the inode number of a file.
+func inode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// run sends the lines of the file until it ends, or until ctx is done when
+// following.
+func (fl *follower) run(ctx context.Context) {
+	defer close(fl.lines)
+	defer func() { fl.file.Close() }()
+	fl.done = ctx.Done()
+
+	for _, text := range fl.header {
+		if !fl.emit(Line{Source: fl.path, Text: text}) {
+			return
+		}
+	}
+	if fl.missed {
+		fl.catchUp()
+	}
+
+	for ctx.Err() == nil {
+		if fl.rotated != nil {
+			fl.drainRotated()
+			continue
+		}
+
+		fl.partial = fl.send(fl.reader, &fl.pos, fl.partial)
+		if !fl.follow {
+			fl.flush(fl.pos)
+			return
+		}
+
+		info, err := os.Stat(fl.path)
+		switch {
+		case err != nil || inode(info) != fl.pos.Inode:
+			fl.rotate()
+		case info.Size() < fl.pos.Offset:
+			fl.catchUp()
+		default:
+			fl.sleep()
+		}
+	}
+}
+
+// rotate keeps the renamed file aside and opens the new one, once created.
+func (fl *follower) rotate() {
+	rotated, rotatedPos := fl.file, fl.pos
+	if err := fl.open(); err != nil {
+		fl.sleep()
+		return
+	}
+	fl.rotated, fl.rotatedPos = rotated, rotatedPos
+}
+
+// drainRotated reads the renamed file until the writer starts writing the
+// new one, then closes it.
+func (fl *follower) drainRotated() {
+	info, err := fl.file.Stat()
+	switched := err == nil && info.Size() > 0
+
+	fl.partial = fl.send(bufio.NewReader(fl.rotated), &fl.rotatedPos, fl.partial)
+	if !switched {
+		fl.sleep()
+		return
+	}
+	fl.flush(fl.rotatedPos)
+	fl.rotated.Close()
+	fl.rotated = nil
+}
+
+// catchUp reads back the lines written after the last read position from
+// the rotated copy, after a copytruncate or a rotation while logwatcher was
+// stopped, then reads the file from its start.
+func (fl *follower) catchUp() {
+	pos := fl.pos
+	if copy := rotatedCopy(fl.path); copy != "" {
+		err := openLogReader(copy, func(r io.Reader) error {
+			if fl.directives {
+				for _, text := range readDirectives(r, pos.Offset) {
+					fl.emit(Line{Source: fl.path, Text: text})
+				}
+			} else if _, err := io.CopyN(io.Discard, r, pos.Offset); err != nil {
+				return err
+			}
+			fl.partial = fl.send(bufio.NewReader(r), &pos, fl.partial)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	fl.flush(pos)
+
+	if _, err := fl.file.Seek(0, io.SeekStart); err != nil {
+		log.Println(err)
+	}
+	fl.reader.Reset(fl.file)
+	info, err := fl.file.Stat()
+	if err == nil {
+		fl.pos = FilePosition{Inode: inode(info)}
+	}
+}
+
+// send sends the complete lines of r, moving pos past each of them, and
+// returns the incomplete last line.
+func (fl *follower) send(r *bufio.Reader, pos *FilePosition, partial string) string {
+	for {
+		s, err := r.ReadString('\n')
+		pos.Offset += int64(len(s))
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return partial + s
+		}
+		if !fl.emit(Line{Source: fl.path, Text: strings.TrimRight(partial+s, "\r\n"), Position: *pos}) {
+			return ""
+		}
+		partial = ""
+	}
+}
+
+// readDirectives reads the first n bytes of r and returns the directive lines
+// found in them, the lines starting with #.
+func readDirectives(r io.Reader, n int64) []string {
+	directives := make([]string, 0)
+	br := bufio.NewReader(io.LimitReader(r, n))
+	for {
+		s, err := br.ReadString('\n')
+		if strings.HasPrefix(s, "#") {
+			directives = append(directives, strings.TrimRight(s, "\r\n"))
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return directives
+		}
+	}
+}
+
+// flush sends the incomplete last line of a file which will not grow anymore.
+func (fl *follower) flush(pos FilePosition) {
+	if fl.partial != "" {
+		fl.emit(Line{Source: fl.path, Text: strings.TrimRight(fl.partial, "\r\n"), Position: pos})
+		fl.partial = ""
+	}
+}
+
+// emit sends a line, unless the follower is stopped first.
+func (fl *follower) emit(line Line) bool {
+	select {
+	case fl.lines <- line:
+		return true
+	case <-fl.done:
+		return false
+	}
+}
+
+func (fl *follower) sleep() {
+	select {
+	case <-time.After(followInterval):
+	case <-fl.done:
+	}
+}
+
+// rotatedSuffix matches the suffixes of rotated copies, a number or a date,
+// optionally compressed.
+var rotatedSuffix = regexp.MustCompile(`^[.-]([0-9]+|[0-9]{4}-[0-9]{2}-[0-9]{2})(\.gz|\.zst)?$`)
+
+// rotatedCopy returns the most recent rotated copy of path, such as
+// access.log.1, access.log.1.gz or access.log-20160511.zst, if any. Other
+// files, like access.log.bak or access.log.swp, are not rotated copies.
+func rotatedCopy(path string) string {
+	matches, _ := filepath.Glob(path + ".*")
+	dated, _ := filepath.Glob(path + "-*")
+	matches = append(matches, dated...)
+
+	newest, newestTime := "", time.Time{}
+	for _, match := range matches {
+		if !rotatedSuffix.MatchString(strings.TrimPrefix(match, path)) {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = match, info.ModTime()
+		}
+	}
+	return newest
+}
+
+// openLogReader calls fn with the content of file, decompressed when it is
+// gzip or zstd compressed, or with standard input.
+func openLogReader(file string, fn func(r io.Reader) error) error {
+	f := os.Stdin
+	if file != StdinLogFile {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return fn(gz)
+	case len(magic) == 4 && magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return fn(zr)
+	}
+	return fn(br)
+}
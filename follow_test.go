@@ -0,0 +1,159 @@
+package logwatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "gopkg.in/check.v1"
+)
+
+type FollowSuite struct {
+	dir string
+}
+
+var _ = Suite(&FollowSuite{})
+
+func (s *FollowSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func writeLines(f *os.File, prefix string, n int) {
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(f, "%s%d\n", prefix, i)
+	}
+}
+
+func expectLines(c *C, fl *follower, prefix string, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case line := <-fl.lines:
+			c.Assert(line.Text, Equals, fmt.Sprintf("%s%d", prefix, i))
+		case <-time.After(3 * time.Second):
+			c.Fatalf("missing line %s%d", prefix, i)
+		}
+	}
+}
+
+func (s *FollowSuite) TestFollowerRenameOk(c *C) {
+	path := filepath.Join(s.dir, "access.log")
+	w, err := os.Create(path)
+	c.Assert(err, IsNil)
+	writeLines(w, "a", 5)
+
+	fl, err := openFollower(path, true, true)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	expectLines(c, fl, "a", 5)
+
+	// The writer keeps writing the renamed file until it reopens its log.
+	c.Assert(os.Rename(path, path+".1"), IsNil)
+	writeLines(w, "b", 5)
+	nw, err := os.Create(path)
+	c.Assert(err, IsNil)
+	time.Sleep(2 * followInterval)
+	writeLines(w, "c", 5)
+	w.Close()
+	c.Assert(os.Remove(path+".1"), IsNil)
+	writeLines(nw, "d", 5)
+	nw.Close()
+
+	expectLines(c, fl, "b", 5)
+	expectLines(c, fl, "c", 5)
+	expectLines(c, fl, "d", 5)
+}
+
+func (s *FollowSuite) testFollowerCopyTruncate(c *C, compress func([]byte) []byte, suffix string) {
+	path := filepath.Join(s.dir, "access.log")
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	writeLines(w, "a", 20)
+
+	fl, err := openFollower(path, true, true)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	expectLines(c, fl, "a", 20)
+
+	// Lines written while the follower waits, just before the copy and the
+	// truncation, are only found in the rotated copy.
+	time.Sleep(followInterval / 5)
+	writeLines(w, "b", 3)
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(path+suffix, compress(data), 0644), IsNil)
+	c.Assert(w.Truncate(0), IsNil)
+	writeLines(w, "c", 3)
+
+	expectLines(c, fl, "b", 3)
+	expectLines(c, fl, "c", 3)
+}
+
+func (s *FollowSuite) TestFollowerCopyTruncateOk(c *C) {
+	s.testFollowerCopyTruncate(c, func(data []byte) []byte { return data }, ".1")
+}
+
+func (s *FollowSuite) TestFollowerCopyTruncateGzipOk(c *C) {
+	s.testFollowerCopyTruncate(c, func(data []byte) []byte {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write(data)
+		gz.Close()
+		return buf.Bytes()
+	}, ".1.gz")
+}
+
+func (s *FollowSuite) TestFollowerCopyTruncateZstdOk(c *C) {
+	s.testFollowerCopyTruncate(c, func(data []byte) []byte {
+		enc, _ := zstd.NewWriter(nil)
+		defer enc.Close()
+		return enc.EncodeAll(data, nil)
+	}, "-20160511.zst")
+}
+
+func (s *FollowSuite) TestFollowerNoFollowOk(c *C) {
+	path := filepath.Join(s.dir, "access.log")
+	c.Assert(os.WriteFile(path, []byte("a0\na1\na2"), 0644), IsNil)
+
+	fl, err := openFollower(path, true, false)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	expectLines(c, fl, "a", 3)
+	_, ok := <-fl.lines
+	c.Assert(ok, Equals, false)
+
+	_, err = openFollower(filepath.Join(s.dir, "nope.log"), true, false)
+	c.Assert(err, ErrorMatches, "open .*nope.log: no such file or directory")
+}
+
+func (s *FollowSuite) TestRotatedCopy(c *C) {
+	path := filepath.Join(s.dir, "access.log")
+	c.Assert(rotatedCopy(path), Equals, "")
+	now := time.Now()
+	for i, name := range []string{"access.log.2.gz", "access.log-2016-05-11", "access.log-20160511.zst", "access.log.1",
+		"access.log.bak", "access.log.swp", "access.log.lock", "access.log.1.tmp"} {
+		file := filepath.Join(s.dir, name)
+		c.Assert(os.WriteFile(file, nil, 0644), IsNil)
+		c.Assert(os.Chtimes(file, now, now.Add(time.Duration(i)*time.Second)), IsNil)
+		if i < 4 {
+			c.Assert(rotatedCopy(path), Equals, file)
+		}
+	}
+	c.Assert(rotatedCopy(path), Equals, filepath.Join(s.dir, "access.log.1"))
+}
+
+func (s *FollowSuite) TestReadLogFileZstdOk(c *C) {
+	path := filepath.Join(s.dir, "access.log.zst")
+	enc, _ := zstd.NewWriter(nil)
+	c.Assert(os.WriteFile(path, enc.EncodeAll([]byte("a0\na1\n"), nil), 0644), IsNil)
+	enc.Close()
+
+	lines := make([]string, 0)
+	c.Assert(readLogFile(path, func(line string) { lines = append(lines, line) }), IsNil)
+	c.Assert(lines, DeepEquals, []string{"a0", "a1"})
+}
@@ -0,0 +1,311 @@
+package logwatcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatToken is either a literal piece of a log format or a named field.
+type formatToken struct {
+	literal string
+	field   string
+}
+
+// FormatParser parses lines with a regular expression compiled from an nginx
+// log_format or an Apache LogFormat directive string. Well known fields fill
+// the CommonLog, every other field ends up in CommonLog.Extra.
+type FormatParser struct {
+	re     *regexp.Regexp
+	fields []string
+}
+
+// apacheDirectives maps Apache LogFormat directives to their nginx variable
+// names, so both syntaxes share the same field handling.
+var apacheDirectives = map[string]string{
+	"a": "remote_addr",
+	"A": "server_addr",
+	"b": "body_bytes_sent",
+	"B": "body_bytes_sent",
+	"D": "request_time_us",
+	"f": "request_filename",
+	"h": "remote_addr",
+	"H": "server_protocol",
+	"I": "bytes_received",
+	"k": "keepalive_requests",
+	"l": "remote_ident",
+	"L": "log_id",
+	"m": "request_method",
+	"O": "bytes_sent",
+	"p": "server_port",
+	"P": "pid",
+	"q": "query_string",
+	"r": "request",
+	"R": "handler",
+	"s": "status",
+	"t": "time_local",
+	"T": "request_time",
+	"u": "remote_user",
+	"U": "uri",
+	"v": "server_name",
+	"V": "host",
+	"X": "connection_status",
+}
+
+// apacheHeaderDirectives maps Apache %{Name}x directives to the prefix of the
+// matching nginx variable.
+var apacheHeaderDirectives = map[string]string{
+	"C": "cookie_",
+	"e": "env_",
+	"i": "http_",
+	"n": "note_",
+	"o": "sent_http_",
+}
+
+func init() {
+	RegisterParser("nginx", func(cfg *Config) (Parser, error) {
+		if cfg.LogPattern == "" {
+			return nil, fmt.Errorf("log format nginx needs a --log-pattern")
+		}
+		return NewNginxFormatParser(cfg.LogPattern)
+	})
+	RegisterParser("apache", func(cfg *Config) (Parser, error) {
+		if cfg.LogPattern == "" {
+			return nil, fmt.Errorf("log format apache needs a --log-pattern")
+		}
+		return NewApacheFormatParser(cfg.LogPattern)
+	})
+}
+
+// NewNginxFormatParser compiles an nginx log_format string such as
+// `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`.
+func NewNginxFormatParser(format string) (*FormatParser, error) {
+	tokens := make([]formatToken, 0)
+	literal := ""
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '$' {
+			literal += string(format[i])
+			continue
+		}
+
+		var name string
+		if i+1 < len(format) && format[i+1] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable at offset %d in log format", i)
+			}
+			name = format[i+2 : i+end]
+			i += end
+		} else {
+			j := i + 1
+			for j < len(format) && isVarChar(format[j]) {
+				j++
+			}
+			name = format[i+1 : j]
+			i = j - 1
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty variable name at offset %d in log format", i)
+		}
+		if literal != "" {
+			tokens = append(tokens, formatToken{literal: literal})
+			literal = ""
+		}
+		tokens = append(tokens, formatToken{field: name})
+	}
+	if literal != "" {
+		tokens = append(tokens, formatToken{literal: literal})
+	}
+
+	return newFormatParser(tokens)
+}
+
+// NewApacheFormatParser compiles an Apache LogFormat string such as
+// `%h %l %u %t "%r" %>s %b %D`.
+func NewApacheFormatParser(format string) (*FormatParser, error) {
+	tokens := make([]formatToken, 0)
+	literal := ""
+
+	for i := 0; i < len(format); i++ {
+		switch {
+		case format[i] == '\\' && i+1 < len(format):
+			i++
+			switch format[i] {
+			case 'n':
+				literal += "\n"
+			case 't':
+				literal += "\t"
+			default:
+				literal += string(format[i])
+			}
+			continue
+		case format[i] != '%':
+			literal += string(format[i])
+			continue
+		case i+1 < len(format) && format[i+1] == '%':
+			literal += "%"
+			i++
+			continue
+		}
+
+		// Skip the optional status condition and request modifiers: %!200,304>s
+		j := i + 1
+		for j < len(format) && strings.IndexByte("!,0123456789<>", format[j]) >= 0 {
+			j++
+		}
+		arg := ""
+		if j < len(format) && format[j] == '{' {
+			end := strings.IndexByte(format[j:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated directive at offset %d in log format", i)
+			}
+			arg = format[j+1 : j+end]
+			j += end + 1
+		}
+		if j >= len(format) {
+			return nil, fmt.Errorf("truncated directive at offset %d in log format", i)
+		}
+		directive := string(format[j])
+		i = j
+
+		var name string
+		if prefix, ok := apacheHeaderDirectives[directive]; ok && arg != "" {
+			name = prefix + strings.ToLower(strings.Replace(arg, "-", "_", -1))
+		} else if name, ok = apacheDirectives[directive]; !ok {
+			return nil, fmt.Errorf("unsupported directive %%%s at offset %d in log format", directive, i)
+		}
+
+		// %t is written between brackets unless a custom time format is given.
+		if directive == "t" && arg == "" {
+			literal += "["
+			tokens = append(tokens, formatToken{literal: literal}, formatToken{field: name})
+			literal = "]"
+			continue
+		}
+		if literal != "" {
+			tokens = append(tokens, formatToken{literal: literal})
+			literal = ""
+		}
+		tokens = append(tokens, formatToken{field: name})
+	}
+	if literal != "" {
+		tokens = append(tokens, formatToken{literal: literal})
+	}
+
+	return newFormatParser(tokens)
+}
+
+func isVarChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func newFormatParser(tokens []formatToken) (*FormatParser, error) {
+	fields := make([]string, 0)
+	expr := "^"
+
+	for i, token := range tokens {
+		if token.field == "" {
+			expr += regexp.QuoteMeta(token.literal)
+			continue
+		}
+		// A field runs up to the first character of the literal following it.
+		switch {
+		case i+1 == len(tokens):
+			expr += "(.*)"
+		case tokens[i+1].field != "":
+			expr += "(.*?)"
+		default:
+			expr += "([^" + regexp.QuoteMeta(tokens[i+1].literal[:1]) + "]*)"
+		}
+		fields = append(fields, token.field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("log format has no fields")
+	}
+
+	re, err := regexp.Compile(expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &FormatParser{re: re, fields: fields}, nil
+}
+
+// Fields returns the names of the fields captured by the parser, in order.
+func (p *FormatParser) Fields() []string {
+	return p.fields
+}
+
+// Parse implements the Parser interface.
+func (p *FormatParser) Parse(line string) (*CommonLog, error) {
+	res := p.re.FindStringSubmatch(line)
+	if res == nil {
+		return nil, ErrNoMatch
+	}
+
+	cl := &CommonLog{}
+	for i, name := range p.fields {
+		value := res[i+1]
+
+		switch name {
+		case "remote_addr":
+			cl.IP = value
+		case "remote_ident":
+			cl.Identifier = value
+		case "remote_user":
+			cl.User = value
+		case "time_local", "time_iso8601":
+			date, err := parseDate(value, CommonLogDate, time.RFC3339)
+			if err != nil {
+				return nil, err
+			}
+			cl.Date = value
+			cl.Time = date
+		case "request":
+			parts := strings.SplitN(value, " ", 3)
+			cl.Method = parts[0]
+			if len(parts) > 1 {
+				cl.Request = parts[1]
+			}
+			if len(parts) > 2 {
+				cl.Proto = parts[2]
+			}
+		case "request_method":
+			cl.Method = value
+		case "request_uri":
+			cl.Request = value
+		case "uri":
+			if cl.Request == "" {
+				cl.Request = value
+			}
+		case "server_protocol":
+			cl.Proto = value
+		case "status":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q", ErrBadStatus, value)
+			}
+			cl.Status = status
+		case "body_bytes_sent":
+			if value != "-" {
+				bytes, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w %q", ErrBadBytes, value)
+				}
+				cl.Bytes = bytes
+			}
+		case "http_referer":
+			cl.Referrer = value
+		case "http_user_agent":
+			cl.UserAgent = value
+		default:
+			if cl.Extra == nil {
+				cl.Extra = make(map[string]string)
+			}
+			cl.Extra[name] = value
+		}
+	}
+	return cl, nil
+}
@@ -0,0 +1,101 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type FormatSuite struct{}
+
+var _ = Suite(&FormatSuite{})
+
+func (s *FormatSuite) TestNginxFormatParserOk(c *C) {
+	p, err := NewNginxFormatParser(`$remote_addr - $remote_user [$time_local] "$request" ` +
+		`$status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time ${upstream_response_time}s $host`)
+	c.Assert(err, IsNil)
+
+	cl, err := p.Parse(`10.0.0.1 - bob [11/May/2016:22:02:21 +0200] "POST /api/users HTTP/1.1" ` +
+		`201 512 "-" "curl/7.47.0" 0.012 0.010s my.site.com`)
+	c.Assert(err, IsNil)
+	c.Assert(cl.IP, Equals, "10.0.0.1")
+	c.Assert(cl.User, Equals, "bob")
+	c.Assert(cl.Date, Equals, "11/May/2016:22:02:21 +0200")
+	c.Assert(cl.Time.Equal(time.Date(2016, 5, 11, 20, 2, 21, 0, time.UTC)), Equals, true)
+	c.Assert(cl.Method, Equals, "POST")
+	c.Assert(cl.Request, Equals, "/api/users")
+	c.Assert(cl.Proto, Equals, "HTTP/1.1")
+	c.Assert(cl.Status, Equals, 201)
+	c.Assert(cl.Bytes, Equals, int64(512))
+	c.Assert(cl.Referrer, Equals, "-")
+	c.Assert(cl.UserAgent, Equals, "curl/7.47.0")
+	c.Assert(cl.Extra, DeepEquals, map[string]string{
+		"request_time":           "0.012",
+		"upstream_response_time": "0.010",
+		"host":                   "my.site.com",
+	})
+}
+
+func (s *FormatSuite) TestNginxFormatParserNoMatchKo(c *C) {
+	p, err := NewNginxFormatParser(`$remote_addr [$time_local] $status`)
+	c.Assert(err, IsNil)
+
+	_, err = p.Parse("10.0.0.1 11/May/2016:22:02:21 +0200 200")
+	c.Assert(err, Equals, ErrNoMatch)
+
+	_, err = p.Parse("10.0.0.1 [11/May/2016:22:02:21 +0200] OK")
+	c.Assert(err, ErrorMatches, `invalid status "OK"`)
+
+	_, err = p.Parse("10.0.0.1 [yesterday] 200")
+	c.Assert(err, ErrorMatches, `invalid date "yesterday"`)
+}
+
+func (s *FormatSuite) TestNginxFormatParserInvalidKo(c *C) {
+	_, err := NewNginxFormatParser(`${remote_addr`)
+	c.Assert(err, ErrorMatches, "unterminated variable .*")
+
+	_, err = NewNginxFormatParser(`no variables here`)
+	c.Assert(err, ErrorMatches, "log format has no fields")
+}
+
+func (s *FormatSuite) TestApacheFormatParserOk(c *C) {
+	p, err := NewApacheFormatParser(`%h %l %u %t \"%r\" %>s %b %D \"%{Referer}i\" \"%{User-agent}i\" %{X-Forwarded-For}i`)
+	c.Assert(err, IsNil)
+	c.Assert(p.Fields(), DeepEquals, []string{"remote_addr", "remote_ident", "remote_user", "time_local",
+		"request", "status", "body_bytes_sent", "request_time_us", "http_referer", "http_user_agent",
+		"http_x_forwarded_for"})
+
+	cl, err := p.Parse(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" ` +
+		`200 - 1234 "http://www.example.com/start.html" "Mozilla/4.08" 10.1.1.1`)
+	c.Assert(err, IsNil)
+	c.Assert(cl.IP, Equals, "127.0.0.1")
+	c.Assert(cl.Identifier, Equals, "-")
+	c.Assert(cl.User, Equals, "frank")
+	c.Assert(cl.Date, Equals, "10/Oct/2000:13:55:36 -0700")
+	c.Assert(cl.Request, Equals, "/apache_pb.gif")
+	c.Assert(cl.Status, Equals, 200)
+	c.Assert(cl.Bytes, Equals, int64(0))
+	c.Assert(cl.Referrer, Equals, "http://www.example.com/start.html")
+	c.Assert(cl.UserAgent, Equals, "Mozilla/4.08")
+	c.Assert(cl.Extra, DeepEquals, map[string]string{
+		"request_time_us":      "1234",
+		"http_x_forwarded_for": "10.1.1.1",
+	})
+}
+
+func (s *FormatSuite) TestApacheFormatParserInvalidKo(c *C) {
+	_, err := NewApacheFormatParser(`%h %Z`)
+	c.Assert(err, ErrorMatches, "unsupported directive %Z .*")
+
+	_, err = NewApacheFormatParser(`%h %>`)
+	c.Assert(err, ErrorMatches, "truncated directive .*")
+}
+
+func (s *FormatSuite) TestNewParserPatternKo(c *C) {
+	_, err := NewParser(&Config{LogFormat: "nginx"})
+	c.Assert(err, ErrorMatches, "log format nginx needs a --log-pattern")
+
+	p, err := NewParser(&Config{LogFormat: "apache", LogPattern: "%h %>s"})
+	c.Assert(err, IsNil)
+	c.Assert(p, FitsTypeOf, &FormatParser{})
+}
@@ -0,0 +1,18 @@
+module github.com/rustx/logwatcher
+
+go 1.21
+
+require (
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/jroimartin/gocui v0.5.0
+	github.com/klauspost/compress v1.17.11
+	gopkg.in/check.v1 v1.0.0-20200902074654-038fdea0a05b
+)
+
+require (
+	github.com/kr/text v0.1.0 // indirect
+	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/nsf/termbox-go v1.1.1 // indirect
+	golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4 // indirect
+)
@@ -0,0 +1,181 @@
+package logwatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadJSON is returned for lines which do not hold a JSON object.
+var ErrBadJSON = errors.New("invalid json")
+
+// DefaultJSONFields maps CommonLog fields to the keys written by Caddy access
+// logs, used when no --json-field option is given.
+var DefaultJSONFields = map[string]string{
+	"ip":         "request.remote_ip",
+	"date":       "ts",
+	"method":     "request.method",
+	"request":    "request.uri",
+	"proto":      "request.proto",
+	"status":     "status",
+	"bytes":      "size",
+	"referrer":   "request.headers.Referer",
+	"user_agent": "request.headers.User-Agent",
+}
+
+// JSONParser parses access logs written as one JSON object per line. Its
+// mapping tells which JSON key, or dotted path to a nested key, feeds each
+// CommonLog field. Mapped names which are not CommonLog fields end up in
+// CommonLog.Extra.
+type JSONParser struct {
+	fields map[string][]string
+}
+
+func init() {
+	RegisterParser("json", func(cfg *Config) (Parser, error) {
+		return NewJSONParser(cfg.JSONFields)
+	})
+}
+
+// NewJSONParser returns a JSON lines parser using the given field mapping,
+// or DefaultJSONFields when it is empty.
+func NewJSONParser(mapping map[string]string) (*JSONParser, error) {
+	if len(mapping) == 0 {
+		mapping = DefaultJSONFields
+	}
+
+	fields := make(map[string][]string)
+	for name, path := range mapping {
+		if name == "" || path == "" {
+			return nil, fmt.Errorf("invalid json field mapping %q:%q", name, path)
+		}
+		fields[name] = strings.Split(path, ".")
+	}
+	return &JSONParser{fields: fields}, nil
+}
+
+// Parse implements the Parser interface.
+func (p *JSONParser) Parse(line string) (*CommonLog, error) {
+	var doc map[string]interface{}
+
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return nil, ErrBadJSON
+	}
+
+	cl := &CommonLog{}
+	matched := false
+	for name, path := range p.fields {
+		value, ok := lookupJSON(doc, path)
+		if !ok {
+			continue
+		}
+		if name == "request" || name == "status" {
+			matched = true
+		}
+
+		switch name {
+		case "ip":
+			cl.IP = value
+		case "identifier":
+			cl.Identifier = value
+		case "user":
+			cl.User = value
+		case "date":
+			date, err := parseJSONDate(value)
+			if err != nil {
+				return nil, err
+			}
+			cl.Date = value
+			cl.Time = date
+		case "method":
+			cl.Method = value
+		case "request":
+			cl.Request = value
+		case "proto":
+			cl.Proto = value
+		case "status":
+			status, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q", ErrBadStatus, value)
+			}
+			cl.Status = int(status)
+		case "bytes":
+			bytes, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q", ErrBadBytes, value)
+			}
+			cl.Bytes = int64(bytes)
+		case "referrer":
+			cl.Referrer = value
+		case "user_agent":
+			cl.UserAgent = value
+		default:
+			if cl.Extra == nil {
+				cl.Extra = make(map[string]string)
+			}
+			cl.Extra[name] = value
+		}
+	}
+	// Documents with neither a request nor a status are not access logs.
+	if !matched {
+		return nil, ErrNoMatch
+	}
+	return cl, nil
+}
+
+// parseJSONDate parses dates written as RFC 3339 strings, Common Log Format
+// strings, or numbers of seconds (milliseconds for large values) since epoch.
+func parseJSONDate(value string) (time.Time, error) {
+	if ts, err := strconv.ParseFloat(value, 64); err == nil {
+		if ts > 1e12 {
+			ts /= 1000
+		}
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return parseDate(value, time.RFC3339Nano, CommonLogDate)
+}
+
+// lookupJSON follows path into doc and returns the value found there as a
+// string. Arrays, such as Caddy header values, yield their first element.
+func lookupJSON(doc interface{}, path []string) (string, bool) {
+	for _, key := range path {
+		obj, ok := firstJSON(doc).(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if doc, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := firstJSON(doc).(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		b, _ := json.Marshal(v)
+		return string(b), true
+	}
+}
+
+func firstJSON(v interface{}) interface{} {
+	if a, ok := v.([]interface{}); ok {
+		if len(a) == 0 {
+			return nil
+		}
+		return a[0]
+	}
+	return v
+}
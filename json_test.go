@@ -0,0 +1,76 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type JSONSuite struct{}
+
+var _ = Suite(&JSONSuite{})
+
+func (s *JSONSuite) TestJSONParserDefaultOk(c *C) {
+	p, err := NewParser(&Config{LogFormat: "json"})
+	c.Assert(err, IsNil)
+
+	cl, err := p.Parse(`{"level":"info","ts":1463004141.123,"logger":"http.log.access","request":{"remote_ip":"10.0.0.1",` +
+		`"proto":"HTTP/2.0","method":"GET","uri":"/pages/create","headers":{"User-Agent":["curl/7.47.0"]}},` +
+		`"duration":0.0012,"size":1024,"status":404}`)
+	c.Assert(err, IsNil)
+	c.Assert(cl.IP, Equals, "10.0.0.1")
+	c.Assert(cl.Date, Equals, "1463004141.123")
+	c.Assert(cl.Time.Unix(), Equals, int64(1463004141))
+	c.Assert(cl.Method, Equals, "GET")
+	c.Assert(cl.Request, Equals, "/pages/create")
+	c.Assert(cl.Proto, Equals, "HTTP/2.0")
+	c.Assert(cl.Status, Equals, 404)
+	c.Assert(cl.Bytes, Equals, int64(1024))
+	c.Assert(cl.Referrer, Equals, "")
+	c.Assert(cl.UserAgent, Equals, "curl/7.47.0")
+}
+
+func (s *JSONSuite) TestJSONParserMappingOk(c *C) {
+	p, err := NewJSONParser(map[string]string{
+		"ip":       "ClientHost",
+		"request":  "RequestPath",
+		"status":   "DownstreamStatus",
+		"bytes":    "DownstreamContentSize",
+		"router":   "RouterName",
+		"duration": "Duration",
+		"date":     "StartUTC",
+	})
+	c.Assert(err, IsNil)
+
+	cl, err := p.Parse(`{"ClientHost":"192.168.1.2","RequestPath":"/api/users","DownstreamStatus":502,` +
+		`"DownstreamContentSize":"17","RouterName":"api@docker","Duration":1234567,"StartUTC":"2016-05-11T20:02:21.5Z"}`)
+	c.Assert(err, IsNil)
+	c.Assert(cl.IP, Equals, "192.168.1.2")
+	c.Assert(cl.Request, Equals, "/api/users")
+	c.Assert(cl.Status, Equals, 502)
+	c.Assert(cl.Bytes, Equals, int64(17))
+	c.Assert(cl.Time.Equal(time.Date(2016, 5, 11, 20, 2, 21, 5e8, time.UTC)), Equals, true)
+	c.Assert(cl.Extra, DeepEquals, map[string]string{"router": "api@docker", "duration": "1234567"})
+}
+
+func (s *JSONSuite) TestJSONParserKo(c *C) {
+	p, err := NewJSONParser(nil)
+	c.Assert(err, IsNil)
+
+	_, err = p.Parse(`127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET / HTTP/1.1" 200 0`)
+	c.Assert(err, Equals, ErrBadJSON)
+
+	_, err = p.Parse(`{"status":"OK"}`)
+	c.Assert(err, ErrorMatches, `invalid status "OK"`)
+
+	_, err = p.Parse(`{"ts":"yesterday"}`)
+	c.Assert(err, ErrorMatches, `invalid date "yesterday"`)
+
+	for _, line := range []string{`{}`, `{"msg":"x"}`} {
+		_, err = p.Parse(line)
+		c.Assert(err, Equals, ErrNoMatch, Commentf("line %q", line))
+	}
+
+	_, err = NewJSONParser(map[string]string{"ip": ""})
+	c.Assert(err, ErrorMatches, "invalid json field mapping .*")
+}
@@ -1,11 +1,13 @@
-package main
+package logwatcher
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,8 +26,19 @@ const letters = "abcdefghijklmnopqrstuvwxyz"
 var (
 	_      = Suite(&LogwatcherSuite{})
 	secret = rand.NewSource(time.Now().UnixNano())
+	config Config
 )
 
+// openTestPipeline opens the sources of cfg in a Pipeline whose parsed lines
+// are read by the test rather than by Run.
+func openTestPipeline(ctx context.Context, cfg *Config, clock Clock) (*Pipeline, *chanAggregator, error) {
+	agg := &chanAggregator{events: make(chan CommonLog), errs: make(chan ParseError)}
+	p := NewPipeline(cfg, clock)
+	p.Sources = NewSources(cfg, nil)
+	p.Aggregator = agg
+	return p, agg, p.Open(ctx)
+}
+
 func randIp(r *rand.Rand) string {
 	return fmt.Sprintf("%d.%d.%d.%d", r.Intn(255), r.Intn(255), r.Intn(255), r.Intn(255))
 }
@@ -69,7 +82,8 @@ func generateLogLines(valid bool) (string, *CommonLog) {
 	}
 	cl.Request = randReq(r)
 	cl.IP = randIp(r)
-	cl.Date = time.Now().Format(time.StampMilli)
+	cl.Date = time.Now().Format(CommonLogDate)
+	cl.Time, _ = time.Parse(CommonLogDate, cl.Date)
 	cl.Bytes = int64(r.Intn(500))
 	cl.Status = statusList[r.Intn(len(statusList))]
 
@@ -79,6 +93,14 @@ func generateLogLines(valid bool) (string, *CommonLog) {
 	return log, cl
 }
 
+// generateParsedLogLine returns a log line of generateLogLines, and the
+// CommonLog its parsers return.
+func generateParsedLogLine() (string, *CommonLog) {
+	line, cl := generateLogLines(true)
+	cl.Request = "/" + cl.Request
+	return line, cl
+}
+
 func writeTmpLogFile(file string, lines int, valid bool) error {
 	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -135,31 +157,41 @@ func (s *LogwatcherSuite) TestLogReaderOk(c *C) {
 
 	logStats := make([]*CommonLog, 0)
 	logEvents := make([]string, 0)
+	logErrors := make([]ParseError, 0)
 
-	lw := Logwatcher{
-		StartTime: time.Now(),
-		Config:    &config,
-	}
-	lw.LogFile = filepath.Join(s.dir, s.file)
+	cfg := config
+	cfg.LogFile = []string{filepath.Join(s.dir, s.file)}
 
-	go lw.LogReader()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, agg, err := openTestPipeline(ctx, &cfg, nil)
+	c.Assert(err, IsNil)
+	go p.read(ctx)
 
 loop:
 	for {
 		select {
-		case logStat := <-logTailC:
+		case logStat := <-agg.events:
 			c.Log(logStat)
 			logStats = append(logStats, &logStat)
 			c.Assert(logStat, FitsTypeOf, CommonLog{})
-		case logEvent := <-logDumpC:
+		case logEvent := <-p.lines.c:
 			c.Log(logEvent)
 			c.Assert(logEvent, FitsTypeOf, "")
 			logEvents = append(logEvents, logEvent)
+		case logError := <-agg.errs:
+			c.Log(logError)
+			c.Assert(logError.Reason, Equals, ErrNoMatch.Error())
+			logErrors = append(logErrors, logError)
 		case <-startTimer.C:
-			go writeTmpLogFile(lw.LogFile, 100, true)
-			c.Log("Entering writeTmpLogfile valid")
-			//go writeTmpLogFile(lw.LogFile, 100, false)
-			//c.Log("Entering writeTmpLogfile not valid")
+			// The writers take turns, as the lines generated share one
+			// rand.Rand.
+			go func() {
+				c.Log("Entering writeTmpLogfile valid")
+				writeTmpLogFile(cfg.LogFile[0], 100, true)
+				c.Log("Entering writeTmpLogfile not valid")
+				writeTmpLogFile(cfg.LogFile[0], 100, false)
+			}()
 		case <-mainTimer.C:
 			break loop
 		}
@@ -167,21 +199,236 @@ loop:
 
 	c.Log("LogStats length ", len(logStats))
 	c.Log("LogEvents length ", len(logEvents))
+	c.Log("LogErrors length ", len(logErrors))
 	c.Assert(logStats, HasLen, 100)
 	c.Assert(logEvents, HasLen, 100)
+	c.Assert(logErrors, HasLen, 100)
+}
+
+func (s *LogwatcherSuite) TestLogReaderReplayOk(c *C) {
+	mainTimer := time.NewTimer(time.Duration(5) * time.Second)
+
+	cfg := config
+	cfg.Replay = true
+	cfg.AlertInterval = 120
+	clock := NewReplayClock(0)
+	clock.Start()
+	cfg.LogFile = []string{filepath.Join(s.dir, "replay-access.log")}
+	c.Assert(writeTmpLogFile(cfg.LogFile[0], 100, true), IsNil)
+
+	p, agg, err := openTestPipeline(context.Background(), &cfg, clock)
+	c.Assert(err, IsNil)
+	readerC := make(chan bool)
+	go func() {
+		p.read(context.Background())
+		readerC <- true
+	}()
+
+	logStats := 0
+loop:
+	for {
+		select {
+		case <-agg.events:
+			logStats++
+		case <-p.lines.c:
+		case <-readerC:
+			break loop
+		case <-mainTimer.C:
+			c.Fatal("replay did not stop at the end of the file")
+		}
+	}
+
+	c.Assert(logStats, Equals, 100)
+	// The clock runs past the last line to close the last alert window.
+	c.Assert(clock.Now().After(time.Now().Add(time.Minute)), Equals, true)
+}
+
+func (s *LogwatcherSuite) TestLogReaderStdinOk(c *C) {
+	mainTimer := time.NewTimer(time.Duration(5) * time.Second)
+
+	file := filepath.Join(s.dir, "stdin-access.log")
+	c.Assert(writeTmpLogFile(file, 50, true), IsNil)
+	stdin, err := os.Open(file)
+	c.Assert(err, IsNil)
+	defer stdin.Close()
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	os.Stdin = stdin
+
+	cfg := config
+	cfg.Replay = true
+	cfg.AlertInterval = 120
+	cfg.LogFile = []string{StdinLogFile}
+	clock := NewReplayClock(0)
+	clock.Start()
+
+	p, agg, err := openTestPipeline(context.Background(), &cfg, clock)
+	c.Assert(err, IsNil)
+	readerC := make(chan bool)
+	go func() {
+		p.read(context.Background())
+		readerC <- true
+	}()
+
+	logStats := 0
+loop:
+	for {
+		select {
+		case item := <-agg.events:
+			c.Assert(item.Source, Equals, "stdin")
+			logStats++
+		case <-p.lines.c:
+		case <-readerC:
+			break loop
+		case <-mainTimer.C:
+			c.Fatal("reader did not stop at the end of stdin")
+		}
+	}
+
+	c.Assert(logStats, Equals, 50)
+}
+
+func (s *LogwatcherSuite) TestLogReaderGlobOk(c *C) {
+	mainTimer := time.NewTimer(time.Duration(4) * time.Second)
+	startTimer := time.NewTimer(time.Duration(1) * time.Second)
+
+	cfg := config
+	cfg.GlobInterval = 1
+	dir := c.MkDir()
+	first := filepath.Join(dir, "first.access.log")
+	second := filepath.Join(dir, "second.access.log")
+	third := filepath.Join(dir, "third.access.log")
+	c.Assert(writeTmpLogFile(first, 0, true), IsNil)
+	c.Assert(writeTmpLogFile(second, 0, true), IsNil)
+	cfg.LogFile = []string{filepath.Join(dir, "*.access.log"), first}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, agg, err := openTestPipeline(ctx, &cfg, nil)
+	c.Assert(err, IsNil)
+	go p.read(ctx)
+
+	sources := make(map[string]int)
+loop:
+	for {
+		select {
+		case logStat := <-agg.events:
+			sources[logStat.Source]++
+		case <-p.lines.c:
+		case <-startTimer.C:
+			go func() {
+				writeTmpLogFile(first, 10, true)
+				writeTmpLogFile(second, 20, true)
+				// New files are read from their start.
+				writeTmpLogFile(third, 30, true)
+			}()
+		case <-mainTimer.C:
+			break loop
+		}
+	}
+
+	c.Assert(sources, DeepEquals, map[string]int{first: 10, second: 20, third: 30})
+}
+
+func (s *LogwatcherSuite) TestExpandLogFiles(c *C) {
+	dir := c.MkDir()
+	for _, name := range []string{"b.log", "a.log", "c.txt"} {
+		c.Assert(writeTmpLogFile(filepath.Join(dir, name), 0, true), IsNil)
+	}
+
+	files, err := ExpandLogFiles([]string{filepath.Join(dir, "*.log"), filepath.Join(dir, "a.log"), "/var/log/missing.log"})
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), "/var/log/missing.log"})
+
+	_, err = ExpandLogFiles([]string{"[-"})
+	c.Assert(err, NotNil)
+}
+
+func (s *LogwatcherSuite) TestLoadSources(c *C) {
+	lw := Watcher{
+		StartTime:  time.Now(),
+		Config:     &config,
+		StatsTotal: &StatsTotal{},
+	}
+	lw.LoadSources([]*CommonLog{
+		{Request: "/a", Status: 200, Source: "a.log"},
+		{Request: "/b", Status: 500, Source: "b.log"},
+		{Request: "/b", Status: 200, Source: "b.log"},
+	})
+	c.Assert(lw.SourceNames(), DeepEquals, []string{"a.log", "b.log"})
+	c.Assert(lw.Sources["a.log"].TotalHits, Equals, 1)
+	c.Assert(lw.Sources["b.log"].TotalHits, Equals, 2)
+	c.Assert(lw.Sources["b.log"].Total5xx, Equals, 1)
+}
+
+func (s *LogwatcherSuite) TestLogReaderDeadLetterOk(c *C) {
+	mainTimer := time.NewTimer(time.Duration(3) * time.Second)
+	startTimer := time.NewTimer(time.Duration(1) * time.Second)
+
+	cfg := config
+	cfg.LogFile = []string{filepath.Join(s.dir, "dead-access.log")}
+	cfg.DeadLetterFile = filepath.Join(s.dir, "dead.log")
+	c.Assert(writeTmpLogFile(cfg.LogFile[0], 0, true), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, agg, err := openTestPipeline(ctx, &cfg, nil)
+	c.Assert(err, IsNil)
+	go p.read(ctx)
+
+	logErrors := 0
+loop:
+	for {
+		select {
+		case <-agg.events:
+		case <-p.lines.c:
+		case <-agg.errs:
+			logErrors++
+		case <-startTimer.C:
+			go writeTmpLogFile(cfg.LogFile[0], 10, false)
+		case <-mainTimer.C:
+			break loop
+		}
+	}
+
+	c.Assert(logErrors, Equals, 10)
+	dead, err := ioutil.ReadFile(cfg.DeadLetterFile)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(dead), "\n"), Equals, 10)
+}
+
+func (s *LogwatcherSuite) TestLoadOnParseError(c *C) {
+	lw := Watcher{
+		StartTime:   time.Now(),
+		Config:      &config,
+		StatsErrors: &StatsErrors{ErrorReasons: make(map[string]int)},
+	}
+	for i := 0; i < 2*maxErrorSamples; i++ {
+		lw.LoadOnParseError(NewParseError(fmt.Sprintf("line %d", i), ErrNoMatch))
+	}
+	lw.LoadOnParseError(NewParseError("last", fmt.Errorf("%w %q", ErrBadStatus, "OK")))
+
+	c.Assert(lw.TotalErrors, Equals, 2*maxErrorSamples+1)
+	c.Assert(lw.ErrorReasons, DeepEquals, map[string]int{"line does not match log format": 20, "invalid status": 1})
+	c.Assert(lw.ErrorSamples, HasLen, maxErrorSamples)
+	c.Assert(lw.ErrorSamples[maxErrorSamples-1], Equals, "last")
+}
+
+func (s *LogwatcherSuite) TestSection(c *C) {
+	c.Assert(section("/pages/create"), Equals, "/pages")
+	c.Assert(section("/pages"), Equals, "/pages")
+	c.Assert(section("/"), Equals, "/")
+	c.Assert(section(""), Equals, "/")
+	c.Assert(section("*"), Equals, "/")
 }
 
 func (s *LogwatcherSuite) TestLogReaderFileNoExistKo(c *C) {
 	logStats := make([]*CommonLog, 0)
 	logEvents := make([]string, 0)
 
-	lw := Logwatcher{
-		StartTime: time.Now(),
-		Config:    &config,
-	}
-	lw.LogFile = "test"
+	cfg := config
+	cfg.LogFile = []string{"test"}
 
-	if err := lw.LogReader(); err != nil {
+	if _, _, err := openTestPipeline(context.Background(), &cfg, nil); err != nil {
 		c.Assert(err, Not(IsNil))
 		c.Assert(err, ErrorMatches, "open test: no such file or directory")
 	}
@@ -194,28 +441,25 @@ func (s *LogwatcherSuite) TestLogReaderFilePermissionKo(c *C) {
 	logStats := make([]*CommonLog, 0)
 	logEvents := make([]string, 0)
 
-	lw := Logwatcher{
-		StartTime: time.Now(),
-		Config:    &config,
-	}
-	lw.LogFile = filepath.Join(s.dir, s.file)
+	cfg := config
+	cfg.LogFile = []string{filepath.Join(s.dir, s.file)}
 
-	if err := os.Chmod(lw.LogFile, 0300); err != nil {
+	if err := os.Chmod(cfg.LogFile[0], 0300); err != nil {
 		c.Fatal(err)
 	}
-	if err := lw.LogReader(); err != nil {
+	if _, _, err := openTestPipeline(context.Background(), &cfg, nil); err != nil {
 		c.Assert(err, Not(IsNil))
 		c.Assert(err, ErrorMatches, "open .*: permission denied")
 		c.Assert(logStats, HasLen, 0)
 		c.Assert(logEvents, HasLen, 0)
 	}
-	if err := os.Chmod(lw.LogFile, 0644); err != nil {
+	if err := os.Chmod(cfg.LogFile[0], 0644); err != nil {
 		c.Fatal(err)
 	}
 }
 
 func (s *LogwatcherSuite) TestDate(c *C) {
-	lw := Logwatcher{
+	lw := Watcher{
 		StartTime: time.Now(),
 		Config:    &config,
 	}
@@ -228,7 +472,7 @@ func (s *LogwatcherSuite) TestDate(c *C) {
 }
 
 func (s *LogwatcherSuite) TestTimeElapsed(c *C) {
-	lw := Logwatcher{
+	lw := Watcher{
 		StartTime: time.Now(),
 		Config:    &config,
 	}
@@ -240,19 +484,172 @@ func (s *LogwatcherSuite) TestTimeElapsed(c *C) {
 	}
 }
 
-func (s *LogwatcherSuite) TestSortMap(c *C) {
-	r := rand.New(secret)
-	score := make(map[string]int)
+func (s *LogwatcherSuite) TestCollectStatItems(c *C) {
+	lw := Watcher{
+		StartTime: time.Now(),
+		Config:    &config,
+	}
+	logStats := []*CommonLog{
+		{Request: "/pages/create", Status: 200, Referrer: "http://my.site.com/", UserAgent: "curl/7.47.0"},
+		{Request: "/pages/edit", Status: 404, Referrer: "-", UserAgent: "curl/7.47.0"},
+		{Request: "/assets/app.js", Status: 500},
+	}
 
-	for i := 0; i < 100; i++ {
-		score["200"] = r.Intn(100)
-		score["300"] = r.Intn(200)
-		score["400"] = r.Intn(900)
-		score["500"] = r.Intn(500)
-		result := sortMap(score)
+	item := lw.CollectStatItems(&logStats)
+	c.Assert(item.Hits, Equals, 3)
+	c.Assert(item.Status2xx, Equals, 1)
+	c.Assert(item.Status4xx, Equals, 1)
+	c.Assert(item.Status5xx, Equals, 1)
+	c.Assert(item.TopSections, DeepEquals, map[string]int{"/pages": 2, "/assets": 1})
+	c.Assert(item.TopReferrers, DeepEquals, map[string]int{"http://my.site.com/": 1})
+	c.Assert(item.TopUserAgents, DeepEquals, map[string]int{"curl/7.47.0": 2})
+}
+
+func (s *LogwatcherSuite) TestWindowEvents(c *C) {
+	lw := Watcher{
+		StartTime:  time.Now(),
+		Config:     &config,
+		StatsTotal: &StatsTotal{},
+	}
+	now := time.Now()
+	logStats := []*CommonLog{
+		{Request: "/a", Time: now.Add(-15 * time.Second)},
+		{Request: "/b", Time: now.Add(-5 * time.Second)},
+		{Request: "/c", Time: now.Add(5 * time.Second)},
+	}
 
-		c.Log(result)
-		c.Assert(result, Not(IsNil))
+	events := lw.WindowEvents(&logStats, now)
+	c.Assert(events, HasLen, 2)
+	c.Assert(logStats, HasLen, 1)
+	c.Assert(logStats[0].Request, Equals, "/c")
+	c.Assert(lw.Watermark, Equals, now)
+
+	// Out of order lines are bucketed as long as their window is still open.
+	logStats = append(logStats,
+		&CommonLog{Request: "/d", Time: now.Add(1 * time.Second)},
+		&CommonLog{Request: "/e", Time: now.Add(-1 * time.Second)})
+
+	events = lw.WindowEvents(&logStats, now.Add(10*time.Second))
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Request, Equals, "/c")
+	c.Assert(events[1].Request, Equals, "/d")
+	c.Assert(logStats, HasLen, 0)
+	c.Assert(lw.TotalLate, Equals, 1)
+}
+
+func (s *LogwatcherSuite) TestWatcherStartStopOk(c *C) {
+	file := filepath.Join(s.dir, "watched-access.log")
+	c.Assert(writeTmpLogFile(file, 0, true), IsNil)
+	cfg := Config{
+		LogFile:         []string{file},
+		LogFormat:       "clf",
+		RefreshInterval: 1,
+		AlertInterval:   1,
+		AlertThreshold:  1,
+		StateFile:       filepath.Join(s.dir, "watcher-state.json"),
 	}
+	lw, err := New(&cfg)
+	c.Assert(err, IsNil)
+
+	refreshC := make(chan Snapshot, 10)
+	alertC := make(chan Alert, 10)
+	lw.AddSink(SinkFuncs{
+		OnRefresh: func(snap Snapshot) {
+			select {
+			case refreshC <- snap:
+			default:
+			}
+		},
+		OnAlert: func(alert Alert) {
+			select {
+			case alertC <- alert:
+			default:
+			}
+		},
+	})
+	c.Assert(lw.Start(context.Background()), IsNil)
+	c.Assert(writeTmpLogFile(file, 20, true), IsNil)
+
+	timeout := time.After(5 * time.Second)
+	for hits := 0; hits < 20; {
+		select {
+		case snap := <-refreshC:
+			hits = snap.Total.TotalHits
+		case <-timeout:
+			c.Fatal("lines were not counted")
+		}
+	}
+	select {
+	case alert := <-alertC:
+		c.Assert(alert.Recovered, Equals, false)
+		c.Assert(alert.Message, Matches, "High traffic generated an alert .*")
+	case <-timeout:
+		c.Fatal("no alert raised")
+	}
+	c.Assert(lw.Snapshot().Total.TotalHits, Equals, 20)
+
+	// Stopping saves the read position of the file.
+	lw.Stop()
+	state, err := LoadState(cfg.StateFile)
+	c.Assert(err, IsNil)
+	pos, ok := state.Position(file)
+	c.Assert(ok, Equals, true)
+	info, err := os.Stat(file)
+	c.Assert(err, IsNil)
+	c.Assert(pos.Offset, Equals, info.Size())
+}
+
+func (s *LogwatcherSuite) TestWatcherResumeOk(c *C) {
+	file := filepath.Join(s.dir, "resumed-access.log")
+	c.Assert(writeTmpLogFile(file, 0, true), IsNil)
+	cfg := Config{
+		LogFile:         []string{file},
+		LogFormat:       "clf",
+		RefreshInterval: 1,
+		AlertInterval:   1,
+		AlertThreshold:  1000000,
+		QueueSize:       16,
+		ParserWorkers:   4,
+		StateFile:       filepath.Join(s.dir, "resumed-state.json"),
+	}
+
+	// The first run is stopped while the workers parse the lines.
+	lw, err := New(&cfg)
+	c.Assert(err, IsNil)
+	c.Assert(lw.Start(context.Background()), IsNil)
+	c.Assert(writeTmpLogFile(file, 5000, true), IsNil)
+	lw.Stop()
+
+	lw, err = New(&cfg)
+	c.Assert(err, IsNil)
+	c.Assert(lw.Start(context.Background()), IsNil)
+	info, err := os.Stat(file)
+	c.Assert(err, IsNil)
+	timeout := time.After(5 * time.Second)
+	for lw.pipeline.Positions()[file].Offset < info.Size() {
+		select {
+		case <-timeout:
+			c.Fatal("the file was not read")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	lw.Stop()
+
+	// Every line is counted once, in the totals or pending in the state.
+	state, err := LoadState(cfg.StateFile)
+	c.Assert(err, IsNil)
+	c.Assert(state.Totals.TotalHits+state.Totals.TotalLate+len(state.Pending), Equals, 5000)
+}
+
+func (s *LogwatcherSuite) TestNewKo(c *C) {
+	_, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 15})
+	c.Assert(err, ErrorMatches, "the modulo of alertInterval / refreshInterval must be zero .*")
+
+	_, err = New(&Config{LogFormat: "nope", RefreshInterval: 10, AlertInterval: 20})
+	c.Assert(err, NotNil)
 
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 20, LogFile: []string{"test"}})
+	c.Assert(err, IsNil)
+	c.Assert(lw.Start(context.Background()), ErrorMatches, "open test: no such file or directory")
+	lw.Stop()
 }
@@ -0,0 +1,422 @@
+package logwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier is the interface implemented by the outputs sending alerts out of
+// logwatcher, like webhooks. Notify delivers one alert raised or recovered,
+// giving up when ctx is done.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// highTrafficAlert is the name of the high traffic alert in notifications.
+const highTrafficAlert = "high_traffic"
+
+// Name returns the rule of the alert, or high_traffic for the high traffic
+// alert.
+func (a Alert) Name() string {
+	if a.Rule != "" {
+		return a.Rule
+	}
+	return highTrafficAlert
+}
+
+// State returns firing or recovered.
+func (a Alert) State() string {
+	if a.Recovered {
+		return "recovered"
+	}
+	return "firing"
+}
+
+// AlertPayload is the JSON document describing an alert to notifiers, posted
+// by webhooks and written to the standard input of commands.
+type AlertPayload struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Severity string    `json:"severity,omitempty"`
+	Value    float64   `json:"value"`
+	AvgHits  int       `json:"avg_hits"`
+	Source   string    `json:"source,omitempty"`
+	Flapping bool      `json:"flapping"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Payload returns the payload of the alert.
+func (a Alert) Payload() AlertPayload {
+	return AlertPayload{
+		Name:     a.Name(),
+		State:    a.State(),
+		Severity: a.Severity,
+		Value:    a.Value,
+		AvgHits:  a.AvgHits,
+		Source:   a.Source,
+		Flapping: a.Flapping,
+		Message:  a.Message,
+		Time:     a.Time,
+	}
+}
+
+// NewNotifiers returns the notifiers configured by cfg, if any.
+func NewNotifiers(cfg *Config) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0)
+	if len(cfg.NotifyWebhook) == 0 && len(cfg.NotifySlack) == 0 && cfg.NotifyPagerDuty == "" &&
+		len(cfg.NotifyExec) == 0 && cfg.NotifySMTP == "" {
+		return notifiers, nil
+	}
+	timeout := time.Duration(cfg.NotifyTimeout) * time.Second
+	if timeout <= 0 || cfg.NotifyRetries < 0 {
+		return nil, errors.New("the notify timeout must be positive, and the notify retries must not be negative")
+	}
+	for _, target := range cfg.NotifyWebhook {
+		webhook, err := NewWebhookNotifier(target, timeout, cfg.NotifyRetries)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	for _, target := range cfg.NotifySlack {
+		slack, err := NewSlackNotifier(target, cfg.NotifySlackTemplate, timeout, cfg.NotifyRetries)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, slack)
+	}
+	if cfg.NotifyPagerDuty != "" {
+		pagerDuty, err := NewPagerDutyNotifier(cfg.NotifyPagerDutyURL, cfg.NotifyPagerDuty, timeout, cfg.NotifyRetries)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, pagerDuty)
+	}
+	for _, command := range cfg.NotifyExec {
+		notifiers = append(notifiers, &ExecNotifier{Command: command, Timeout: timeout})
+	}
+	if cfg.NotifySMTP != "" {
+		mail, err := NewSMTPNotifier(cfg.NotifySMTP, cfg.NotifySMTPFrom, cfg.NotifySMTPTo, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.NotifySMTPUser != "" {
+			host, _, _ := net.SplitHostPort(cfg.NotifySMTP)
+			mail.Auth = smtp.PlainAuth("", cfg.NotifySMTPUser, os.Getenv(SMTPPasswordEnv), host)
+		}
+		notifiers = append(notifiers, mail)
+	}
+	return notifiers, nil
+}
+
+// NotifySink is a Sink handing the alerts raised or recovered to its
+// notifiers. They are delivered one at a time from a goroutine of its own,
+// so that slow notifiers never delay the pipeline, and dropped when too many
+// are waiting.
+type NotifySink struct {
+	notifiers []Notifier
+	alerts    chan Alert
+	done      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+var (
+	notifyQueueSize = 64
+	// notifyGrace is how long Close waits for the alerts left to be
+	// delivered.
+	notifyGrace = 5 * time.Second
+)
+
+// NewNotifySink returns a NotifySink of notifiers. Run delivers the alerts
+// until Close.
+func NewNotifySink(notifiers []Notifier) *NotifySink {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &NotifySink{
+		notifiers: notifiers,
+		alerts:    make(chan Alert, notifyQueueSize),
+		done:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (s *NotifySink) Line(line string)      {}
+func (s *NotifySink) Refresh(snap Snapshot) {}
+func (s *NotifySink) Average(snap Snapshot) {}
+
+// Alert queues alert for Run.
+func (s *NotifySink) Alert(alert Alert) {
+	select {
+	case s.alerts <- alert:
+	default:
+		log.Println("notification queue full, dropping alert:", alert.Message)
+	}
+}
+
+// Run delivers the alerts to every notifier until Close, logging the
+// notifiers failing.
+func (s *NotifySink) Run() {
+	defer close(s.done)
+	for alert := range s.alerts {
+		if s.ctx.Err() != nil {
+			log.Println("notifiers stopped, dropping alert:", alert.Message)
+			continue
+		}
+		for _, notifier := range s.notifiers {
+			if err := notifier.Notify(s.ctx, alert); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Close stops taking alerts, and waits for Run to deliver the ones waiting
+// for notifyGrace at most. The notifications still running are then
+// cancelled, and the alerts left are dropped.
+func (s *NotifySink) Close() {
+	close(s.alerts)
+	select {
+	case <-s.done:
+	case <-time.After(notifyGrace):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+}
+
+// WebhookNotifier posts the AlertPayload of the alerts to an HTTP endpoint,
+// or the JSON body rendered by Encode for the presets. It tries again Retries
+// times, waiting longer each time, when the request fails or the endpoint
+// answers with a 5xx or 429 status.
+type WebhookNotifier struct {
+	URL     string
+	Timeout time.Duration
+	Retries int
+	Client  *http.Client
+	// Backoff is the wait before the first retry, doubled for each next one.
+	Backoff time.Duration
+	// Encode, when set, renders the body posted instead of the AlertPayload.
+	Encode func(alert Alert) ([]byte, error)
+}
+
+// NewWebhookNotifier returns a WebhookNotifier posting to target, which must
+// be an http or https URL.
+func NewWebhookNotifier(target string, timeout time.Duration, retries int) (*WebhookNotifier, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", target)
+	}
+	return &WebhookNotifier{
+		URL:     target,
+		Timeout: timeout,
+		Retries: retries,
+		Client:  &http.Client{},
+		Backoff: time.Second,
+	}, nil
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	var body []byte
+	var err error
+	if w.Encode != nil {
+		body, err = w.Encode(alert)
+	} else {
+		body, err = json.Marshal(alert.Payload())
+	}
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil || !retry || attempt >= w.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// post posts body once, and returns whether it is worth trying again when it
+// fails.
+func (w *WebhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "logwatcher")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhook %s: %w", w.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// ExecNotifier runs a shell command for every alert, with the fields of the
+// alert in LOGWATCHER_ALERT_* environment variables and its AlertPayload on
+// its standard input. The command is killed after Timeout.
+type ExecNotifier struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *ExecNotifier) Notify(ctx context.Context, alert Alert) error {
+	payload, err := json.Marshal(alert.Payload())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", e.Command)
+	cmd.Stdin = bytes.NewReader(payload)
+	// The children left by the shell may hold its output open.
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"LOGWATCHER_ALERT_NAME="+alert.Name(),
+		"LOGWATCHER_ALERT_STATE="+alert.State(),
+		"LOGWATCHER_ALERT_SEVERITY="+alert.Severity,
+		"LOGWATCHER_ALERT_VALUE="+strconv.FormatFloat(alert.Value, 'f', -1, 64),
+		"LOGWATCHER_ALERT_AVG_HITS="+strconv.Itoa(alert.AvgHits),
+		"LOGWATCHER_ALERT_SOURCE="+alert.Source,
+		"LOGWATCHER_ALERT_FLAPPING="+strconv.FormatBool(alert.Flapping),
+		"LOGWATCHER_ALERT_MESSAGE="+alert.Message,
+		"LOGWATCHER_ALERT_TIME="+alert.Time.Format(time.RFC3339),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %q: %w: %s", e.Command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// SMTPPasswordEnv is the environment variable holding the password of
+// --notify-smtp-user, kept out of the command line.
+const SMTPPasswordEnv = "LOGWATCHER_SMTP_PASSWORD"
+
+// SMTPNotifier mails the alerts through an SMTP server, using STARTTLS when
+// the server offers it.
+type SMTPNotifier struct {
+	Addr    string
+	From    string
+	To      []string
+	Auth    smtp.Auth
+	Timeout time.Duration
+}
+
+// NewSMTPNotifier returns an SMTPNotifier mailing from from to the addresses
+// of to through the server at addr, a host:port.
+func NewSMTPNotifier(addr, from string, to []string, timeout time.Duration) (*SMTPNotifier, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+	}
+	if from == "" || len(to) == 0 {
+		return nil, errors.New("mailing alerts needs a sender and at least one recipient")
+	}
+	return &SMTPNotifier{Addr: addr, From: from, To: to, Timeout: timeout}, nil
+}
+
+func (m *SMTPNotifier) Notify(ctx context.Context, alert Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	if err := m.send(ctx, m.message(alert)); err != nil {
+		return fmt.Errorf("smtp %s: %w", m.Addr, err)
+	}
+	return nil
+}
+
+// message returns the mail of alert, headers included.
+func (m *SMTPNotifier) message(alert Alert) []byte {
+	subject := fmt.Sprintf("[logwatcher] %s %s", alert.Name(), alert.State())
+	if alert.Severity != "" {
+		subject = fmt.Sprintf("[logwatcher] [%s] %s %s", alert.Severity, alert.Name(), alert.State())
+	}
+	if alert.Flapping {
+		subject += " (flapping)"
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", alert.Message)
+	return msg.Bytes()
+}
+
+// send is smtp.SendMail, giving up when ctx is done.
+func (m *SMTPNotifier) send(ctx context.Context, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(m.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Auth != nil {
+		if err := c.Auth(m.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
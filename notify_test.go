@@ -0,0 +1,260 @@
+package logwatcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type NotifySuite struct{}
+
+var _ = Suite(&NotifySuite{})
+
+var testAlert = Alert{
+	Time:     windowStart,
+	Rule:     "errors",
+	Severity: "critical",
+	Value:    3,
+	Message:  "Rule errors [critical] generated an alert - percent 5xx = 3 > 2, triggered at May 11 22:00:00.000",
+}
+
+// webhookServer is a local stand-in of a webhook endpoint, answering with the
+// statuses given in turn, then 200, and keeping the bodies posted.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	ws := &webhookServer{statuses: statuses}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		ws.bodies = append(ws.bodies, body)
+		if len(ws.statuses) > 0 {
+			w.WriteHeader(ws.statuses[0])
+			ws.statuses = ws.statuses[1:]
+		}
+	}))
+	return ws
+}
+
+func (ws *webhookServer) posted() [][]byte {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return append([][]byte(nil), ws.bodies...)
+}
+
+func (s *NotifySuite) TestWebhookNotifierOk(c *C) {
+	ws := newWebhookServer(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer ws.Close()
+	webhook, err := NewWebhookNotifier(ws.URL, time.Second, 2)
+	c.Assert(err, IsNil)
+	webhook.Backoff = time.Millisecond
+
+	c.Assert(webhook.Notify(context.Background(), testAlert), IsNil)
+	c.Assert(ws.posted(), HasLen, 3)
+	payload := AlertPayload{}
+	c.Assert(json.Unmarshal(ws.posted()[2], &payload), IsNil)
+	c.Assert(payload, DeepEquals, AlertPayload{Name: "errors", State: "firing", Severity: "critical", Value: 3,
+		Message: testAlert.Message, Time: windowStart})
+}
+
+func (s *NotifySuite) TestWebhookNotifierKo(c *C) {
+	ws := newWebhookServer(http.StatusBadRequest)
+	defer ws.Close()
+	webhook, err := NewWebhookNotifier(ws.URL, time.Second, 2)
+	c.Assert(err, IsNil)
+	c.Assert(webhook.Notify(context.Background(), testAlert), ErrorMatches, "webhook .*: 400 Bad Request")
+	c.Assert(ws.posted(), HasLen, 1)
+
+	ws = newWebhookServer(500, 500, 500)
+	defer ws.Close()
+	webhook, _ = NewWebhookNotifier(ws.URL, time.Second, 2)
+	webhook.Backoff = time.Millisecond
+	c.Assert(webhook.Notify(context.Background(), testAlert), ErrorMatches, "webhook .*: 500 Internal Server Error")
+	c.Assert(ws.posted(), HasLen, 3)
+
+	_, err = NewWebhookNotifier("localhost:8080/hook", time.Second, 2)
+	c.Assert(err, ErrorMatches, `invalid webhook URL "localhost:8080/hook"`)
+}
+
+func (s *NotifySuite) TestExecNotifier(c *C) {
+	dir := c.MkDir()
+	notifier := &ExecNotifier{
+		Command: "cat > " + filepath.Join(dir, "payload") + "; env | grep ^LOGWATCHER_ALERT_ | sort > " + filepath.Join(dir, "env"),
+		Timeout: 5 * time.Second,
+	}
+	recovered := testAlert
+	recovered.Recovered = true
+	c.Assert(notifier.Notify(context.Background(), recovered), IsNil)
+
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Split(strings.TrimSpace(string(env)), "\n"), DeepEquals, []string{
+		"LOGWATCHER_ALERT_AVG_HITS=0",
+		"LOGWATCHER_ALERT_FLAPPING=false",
+		"LOGWATCHER_ALERT_MESSAGE=" + testAlert.Message,
+		"LOGWATCHER_ALERT_NAME=errors",
+		"LOGWATCHER_ALERT_SEVERITY=critical",
+		"LOGWATCHER_ALERT_SOURCE=",
+		"LOGWATCHER_ALERT_STATE=recovered",
+		"LOGWATCHER_ALERT_TIME=2016-05-11T22:00:00Z",
+		"LOGWATCHER_ALERT_VALUE=3",
+	})
+	payload := AlertPayload{}
+	data, err := os.ReadFile(filepath.Join(dir, "payload"))
+	c.Assert(err, IsNil)
+	c.Assert(json.Unmarshal(data, &payload), IsNil)
+	c.Assert(payload.State, Equals, "recovered")
+
+	notifier = &ExecNotifier{Command: "echo nope >&2; exit 3", Timeout: 5 * time.Second}
+	c.Assert(notifier.Notify(context.Background(), testAlert), ErrorMatches, `command ".*": exit status 3: nope`)
+	notifier = &ExecNotifier{Command: "sleep 5", Timeout: 50 * time.Millisecond}
+	c.Assert(notifier.Notify(context.Background(), testAlert), ErrorMatches, `command "sleep 5": signal: killed: `)
+}
+
+// smtpServer is a local stand-in of an SMTP server, accepting the mails of
+// one connection and sending the envelope and data received on mails.
+func smtpServer(c *C) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	mails := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		received := make([]string, 0)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for data := false; ; {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case data && line == ".":
+				data = false
+				conn.Write([]byte("250 queued\r\n"))
+			case data:
+				received = append(received, line)
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case line == "DATA":
+				data = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case line == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				mails <- received
+				return
+			default:
+				received = append(received, line)
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func (s *NotifySuite) TestSMTPNotifier(c *C) {
+	addr, mails := smtpServer(c)
+	notifier, err := NewSMTPNotifier(addr, "logwatcher@example.com", []string{"ops@example.com", "dev@example.com"}, 5*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(notifier.Notify(context.Background(), testAlert), IsNil)
+
+	mail := <-mails
+	c.Assert(mail[:3], DeepEquals, []string{"MAIL FROM:<logwatcher@example.com>",
+		"RCPT TO:<ops@example.com>", "RCPT TO:<dev@example.com>"})
+	c.Assert(mail[3:6], DeepEquals, []string{"From: logwatcher@example.com", "To: ops@example.com, dev@example.com",
+		"Subject: [logwatcher] [critical] errors firing"})
+	c.Assert(mail[len(mail)-1], Equals, testAlert.Message)
+
+	_, err = NewSMTPNotifier("localhost", "logwatcher@example.com", []string{"ops@example.com"}, time.Second)
+	c.Assert(err, ErrorMatches, `invalid SMTP address "localhost": .*`)
+	_, err = NewSMTPNotifier("localhost:25", "", []string{"ops@example.com"}, time.Second)
+	c.Assert(err, ErrorMatches, "mailing alerts needs a sender and at least one recipient")
+}
+
+func (s *NotifySuite) TestNotifySinkClose(c *C) {
+	ws := newWebhookServer(500, 500, 500, 500, 500, 500, 500, 500)
+	defer ws.Close()
+	webhook, err := NewWebhookNotifier(ws.URL, time.Second, 3)
+	c.Assert(err, IsNil)
+	defer func(grace time.Duration) { notifyGrace = grace }(notifyGrace)
+	notifyGrace = 100 * time.Millisecond
+
+	// The first alert is cancelled while waiting to be retried, and the
+	// others are dropped.
+	sink := NewNotifySink([]Notifier{webhook})
+	go sink.Run()
+	for i := 0; i < 10; i++ {
+		sink.Alert(testAlert)
+	}
+	start := time.Now()
+	sink.Close()
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+	c.Assert(ws.posted(), HasLen, 1)
+}
+
+func (s *NotifySuite) TestWatcherNotify(c *C) {
+	ws := newWebhookServer()
+	defer ws.Close()
+	dir := c.MkDir()
+	file := filepath.Join(dir, "access.log")
+	c.Assert(writeTmpLogFile(file, 0, true), IsNil)
+	lw, err := New(&Config{
+		LogFile:         []string{file},
+		LogFormat:       "clf",
+		RefreshInterval: 1,
+		AlertInterval:   1,
+		AlertThreshold:  1,
+		NotifyWebhook:   []string{ws.URL},
+		NotifyExec:      []string{"cat > " + filepath.Join(dir, "payload")},
+		NotifyTimeout:   5,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(lw.Start(context.Background()), IsNil)
+	c.Assert(writeTmpLogFile(file, 20, true), IsNil)
+
+	timeout := time.After(5 * time.Second)
+	for len(ws.posted()) == 0 {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			c.Fatal("no alert notified")
+		}
+	}
+	// Stopping waits for the notifiers to be done.
+	lw.Stop()
+	payload := AlertPayload{}
+	c.Assert(json.Unmarshal(ws.posted()[0], &payload), IsNil)
+	c.Assert(payload.Name, Equals, "high_traffic")
+	c.Assert(payload.State, Equals, "firing")
+	data, err := os.ReadFile(filepath.Join(dir, "payload"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `\{"name":"high_traffic","state":"firing",.*`)
+
+	_, err = New(&Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 1, NotifyWebhook: []string{ws.URL},
+		NotifyTimeout: 5, Replay: true, ReplaySpeed: "max"})
+	c.Assert(err, ErrorMatches, "alerts can not be notified when replaying")
+	_, err = New(&Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 1, NotifySMTP: "localhost:25", NotifyTimeout: 5})
+	c.Assert(err, ErrorMatches, "mailing alerts needs a sender and at least one recipient")
+}
@@ -0,0 +1,211 @@
+package logwatcher
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Parser is the interface implemented by log format parsers. Parse turns one
+// log line into a CommonLog, or returns an error when the line does not match
+// the format. Lines carrying no record, like W3C directives, return ErrSkipLine.
+type Parser interface {
+	Parse(line string) (*CommonLog, error)
+}
+
+// StatefulParser is implemented by parsers keeping state between the lines of
+// a log, like the W3C one reading the #Fields directives. The lines of their
+// logs are parsed in order, by a single worker.
+type StatefulParser interface {
+	Parser
+	Stateful() bool
+}
+
+const (
+	// DefaultLogFormat is the log format used when none is given.
+	DefaultLogFormat = "clf"
+	// CommonLogDate is the layout of dates in the Common Log Format.
+	CommonLogDate = "02/Jan/2006:15:04:05 -0700"
+)
+
+// ParserFunc builds a Parser from the command line configuration.
+type ParserFunc func(cfg *Config) (Parser, error)
+
+var (
+	// ErrNoMatch is returned by parsers when a line does not match their format.
+	ErrNoMatch = errors.New("line does not match log format")
+	// ErrSkipLine is returned by parsers for lines which hold no log record.
+	ErrSkipLine = errors.New("line holds no log record")
+	// ErrBadStatus is returned by parsers when the status code is not a number.
+	ErrBadStatus = errors.New("invalid status")
+	// ErrBadBytes is returned by parsers when the response size is not a number.
+	ErrBadBytes = errors.New("invalid bytes")
+	// ErrBadDate is returned by parsers when the date can not be parsed.
+	ErrBadDate = errors.New("invalid date")
+
+	parsersMu sync.RWMutex
+	parsers   = make(map[string]ParserFunc)
+)
+
+// ParseError is a log line rejected by the parser, with the reason why.
+type ParseError struct {
+	Line   string
+	Reason string
+	Err    error
+}
+
+// NewParseError returns the ParseError for line. Its reason is the innermost
+// error returned by the parser, so that errors can be counted per reason.
+func NewParseError(line string, err error) ParseError {
+	reason := err
+	for errors.Unwrap(reason) != nil {
+		reason = errors.Unwrap(reason)
+	}
+	return ParseError{Line: line, Reason: reason.Error(), Err: err}
+}
+
+func (e ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// RegisterParser makes a log format available under name for the --log-format option.
+func RegisterParser(name string, fn ParserFunc) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+
+	if fn == nil {
+		panic("logwatcher: RegisterParser parser is nil")
+	}
+	if _, dup := parsers[name]; dup {
+		panic("logwatcher: RegisterParser called twice for parser " + name)
+	}
+	parsers[name] = fn
+}
+
+// Parsers returns the sorted list of registered log format names.
+func Parsers() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewParser returns the parser registered for the log format given in cfg,
+// falling back to the Common Log Format when none is set.
+func NewParser(cfg *Config) (Parser, error) {
+	name := cfg.LogFormat
+	if name == "" {
+		name = DefaultLogFormat
+	}
+
+	parsersMu.RLock()
+	fn, ok := parsers[name]
+	parsersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q (available: %v)", name, Parsers())
+	}
+	return fn(cfg)
+}
+
+// statefulFormat tells whether the parser of the log format given in cfg
+// keeps state between the lines of a log.
+func statefulFormat(cfg *Config) bool {
+	parser, err := NewParser(cfg)
+	if err != nil {
+		return false
+	}
+	stateful, ok := parser.(StatefulParser)
+	return ok && stateful.Stateful()
+}
+
+func init() {
+	RegisterParser(DefaultLogFormat, func(cfg *Config) (Parser, error) {
+		if cfg.ParserEngine == "scan" {
+			return NewScanLogParser(false), nil
+		}
+		return NewCommonLogParser(), nil
+	})
+	RegisterParser("combined", func(cfg *Config) (Parser, error) {
+		if cfg.ParserEngine == "scan" {
+			return NewScanLogParser(true), nil
+		}
+		return NewCombinedLogParser(), nil
+	})
+}
+
+// CommonLogParser parses lines written in the Common Log Format, or in the
+// NCSA Combined Log Format when built with NewCombinedLogParser.
+type CommonLogParser struct {
+	re *regexp.Regexp
+}
+
+// NewCommonLogParser returns a parser for the Common Log Format.
+func NewCommonLogParser() *CommonLogParser {
+	//127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /assets/avatars/avatar4.png HTTP/1.1" 304 0
+	return &CommonLogParser{
+		re: regexp.MustCompile(`^(?P<Ip>[\d\.]+) (?P<identifier>\S+) (?P<user>\S+) \[(?P<date>[^\]]*)\] "(?P<method>\S+) (?P<request>\S+) (?P<proto>\S+)" (?P<status>\d+) (?P<bytes>\d+)`),
+	}
+}
+
+// NewCombinedLogParser returns a parser for the NCSA Combined Log Format, which
+// appends the referrer and user agent to the Common Log Format.
+func NewCombinedLogParser() *CommonLogParser {
+	//127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /assets/avatars/avatar4.png HTTP/1.1" 304 0 "http://my.site.com/" "Mozilla/5.0"
+	return &CommonLogParser{
+		re: regexp.MustCompile(`^(?P<Ip>[\d\.]+) (?P<identifier>\S+) (?P<user>\S+) \[(?P<date>[^\]]*)\] "(?P<method>\S+) (?P<request>\S+) (?P<proto>\S+)" (?P<status>\d+) (?P<bytes>\d+|-) "(?P<referrer>[^"]*)" "(?P<agent>[^"]*)"`),
+	}
+}
+
+// Parse implements the Parser interface.
+func (p *CommonLogParser) Parse(line string) (*CommonLog, error) {
+	res := p.re.FindStringSubmatch(line)
+	if res == nil {
+		return nil, ErrNoMatch
+	}
+	bytes, _ := strconv.ParseInt(res[9], 10, 64)
+	status, _ := strconv.Atoi(res[8])
+
+	cl := &CommonLog{
+		IP:         res[1],
+		Identifier: res[2],
+		User:       res[3],
+		Date:       res[4],
+		Method:     res[5],
+		Request:    res[6],
+		Proto:      res[7],
+		Status:     status,
+		Bytes:      bytes,
+	}
+	if len(res) > 11 {
+		cl.Referrer = res[10]
+		cl.UserAgent = res[11]
+	}
+
+	date, err := parseDate(cl.Date, CommonLogDate)
+	if err != nil {
+		return nil, err
+	}
+	cl.Time = date
+
+	return cl, nil
+}
+
+// parseDate parses value with the first of layouts it matches.
+func parseDate(value string, layouts ...string) (time.Time, error) {
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w %q", ErrBadDate, value)
+}
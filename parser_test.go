@@ -0,0 +1,69 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ParserSuite struct{}
+
+var _ = Suite(&ParserSuite{})
+
+func (s *ParserSuite) TestNewParserDefault(c *C) {
+	p, err := NewParser(&Config{})
+	c.Assert(err, IsNil)
+	c.Assert(p, FitsTypeOf, &CommonLogParser{})
+}
+
+func (s *ParserSuite) TestNewParserUnknownKo(c *C) {
+	p, err := NewParser(&Config{LogFormat: "pouet"})
+	c.Assert(p, IsNil)
+	c.Assert(err, ErrorMatches, `unknown log format "pouet" .*`)
+}
+
+func (s *ParserSuite) TestParsers(c *C) {
+	c.Assert(Parsers(), DeepEquals, []string{"apache", "clf", "combined", "json", "nginx", "w3c"})
+}
+
+func (s *ParserSuite) TestCommonLogParserOk(c *C) {
+	for i := 0; i < 100; i++ {
+		line, expected := generateParsedLogLine()
+		cl, err := NewCommonLogParser().Parse(line)
+		c.Assert(err, IsNil)
+		c.Assert(*cl, DeepEquals, *expected)
+	}
+}
+
+func (s *ParserSuite) TestCommonLogParserNoMatchKo(c *C) {
+	cl, err := NewCommonLogParser().Parse("not a log line")
+	c.Assert(cl, IsNil)
+	c.Assert(err, Equals, ErrNoMatch)
+}
+
+func (s *ParserSuite) TestCombinedLogParserOk(c *C) {
+	line := `127.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /assets/avatars/avatar4.png HTTP/1.1" 304 - "http://my.site.com/pages" "Mozilla/5.0 (X11; Linux x86_64)"`
+
+	cl, err := NewCombinedLogParser().Parse(line)
+	c.Assert(err, IsNil)
+	c.Assert(cl.Request, Equals, "/assets/avatars/avatar4.png")
+	c.Assert(cl.Status, Equals, 304)
+	c.Assert(cl.Bytes, Equals, int64(0))
+	c.Assert(cl.Time.Equal(time.Date(2016, 5, 11, 20, 2, 21, 0, time.UTC)), Equals, true)
+	c.Assert(cl.Referrer, Equals, "http://my.site.com/pages")
+	c.Assert(cl.UserAgent, Equals, "Mozilla/5.0 (X11; Linux x86_64)")
+}
+
+func (s *ParserSuite) TestCombinedLogParserCommonKo(c *C) {
+	line, _ := generateLogLines(true)
+	cl, err := NewCombinedLogParser().Parse(line)
+	c.Assert(cl, IsNil)
+	c.Assert(err, Equals, ErrNoMatch)
+}
+
+func (s *ParserSuite) TestCommonLogParserDateKo(c *C) {
+	cl, err := NewCommonLogParser().Parse(`127.0.0.1 - - [11/05/2016 22:02:21] "GET / HTTP/1.1" 200 0`)
+	c.Assert(cl, IsNil)
+	c.Assert(err, ErrorMatches, `invalid date "11/05/2016 22:02:21"`)
+	c.Assert(NewParseError("", err).Reason, Equals, "invalid date")
+}
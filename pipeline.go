@@ -0,0 +1,501 @@
+package logwatcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Line is a line read from a log source. Sources decoding an envelope, like
+// syslog, tag the line with the source and the date found in it, or set Err
+// when the envelope can not be decoded.
+type Line struct {
+	Source   string
+	Text     string
+	Time     time.Time
+	Position FilePosition
+	Err      error
+
+	// seq numbers the lines in the order they are queued.
+	seq uint64
+}
+
+// Source is the interface implemented by log inputs. Open reports the inputs
+// which can not be read before the pipeline runs. Run sends the lines read on
+// out until the input ends, or until ctx is done.
+type Source interface {
+	Open(ctx context.Context) error
+	Run(ctx context.Context, out chan<- Line)
+}
+
+// Aggregator is the interface implemented by the statistics of a pipeline.
+// Every parser worker counts its lines in a Shard of its own, and Refresh
+// merges the shards when closing a refresh window, while the workers keep
+// adding lines. Alert evaluates the alert windows ending at now, every
+// second, and returns the alerts raised or recovered.
+type Aggregator interface {
+	NewShard() Shard
+	Refresh(now time.Time) Snapshot
+	Alert(now time.Time) ([]Alert, Snapshot)
+}
+
+// Shard counts the parsed lines and the rejected ones of one parser worker.
+// Its methods are called from that worker only.
+type Shard interface {
+	Add(item CommonLog)
+	AddError(perr ParseError)
+}
+
+// Sink is the interface implemented by the outputs of a pipeline. Sinks are
+// called from the goroutine of the pipeline, one at a time, and must not
+// block. Line is the exception, called from a goroutine of its own so that
+// a slow log tail never delays the statistics.
+type Sink interface {
+	// Line is called with the lines counted, as long as it keeps up with
+	// them. The lines it misses are not counted as dropped.
+	Line(line string)
+	// Refresh is called at the end of every refresh window.
+	Refresh(snap Snapshot)
+	// Alert is called when an alert is raised or recovered.
+	Alert(alert Alert)
+	// Average is called with the averages of the alert window every time
+	// it is evaluated, every second.
+	Average(snap Snapshot)
+}
+
+// SinkFuncs is a Sink calling the functions which are set.
+type SinkFuncs struct {
+	OnLine    func(line string)
+	OnRefresh func(Snapshot)
+	OnAlert   func(Alert)
+	OnAverage func(Snapshot)
+}
+
+func (s SinkFuncs) Line(line string) {
+	if s.OnLine != nil {
+		s.OnLine(line)
+	}
+}
+
+func (s SinkFuncs) Refresh(snap Snapshot) {
+	if s.OnRefresh != nil {
+		s.OnRefresh(snap)
+	}
+}
+
+func (s SinkFuncs) Alert(alert Alert) {
+	if s.OnAlert != nil {
+		s.OnAlert(alert)
+	}
+}
+
+func (s SinkFuncs) Average(snap Snapshot) {
+	if s.OnAverage != nil {
+		s.OnAverage(snap)
+	}
+}
+
+// Pipeline reads the lines of its Sources, parses them with a pool of parser
+// workers, counts them with its Aggregator and hands the results to its
+// Sinks. The lines read wait for the workers in a bounded queue, which
+// applies the configured overflow policy when full.
+type Pipeline struct {
+	Sources    []Source
+	Aggregator Aggregator
+	Sinks      []Sink
+	// Checkpoint, when set, is called with the positions up to which every
+	// line of the log files was parsed, every CheckpointInterval and when the
+	// pipeline stops, from the goroutine closing the windows. The workers
+	// wait meanwhile, so that the lines counted agree with the positions.
+	Checkpoint         func(files map[string]FilePosition)
+	CheckpointInterval time.Duration
+
+	config  *Config
+	clock   Clock
+	dl      *deadLetter
+	queue   *queue[Line]
+	lines   *queue[string]
+	dropped atomic.Uint64
+	queued  atomic.Uint64
+
+	// The workers hold checkpointMu for reading from before they take a
+	// line off the queue until they are done with it, and checkpoints for
+	// writing. Checkpoints close pause to wake the workers waiting for a line.
+	checkpointMu sync.RWMutex
+	pause        chan struct{}
+	mu           sync.Mutex
+	positions    map[string]queuedPosition
+}
+
+// queuedPosition is the position of a line of a log file, with its sequence
+// number.
+type queuedPosition struct {
+	FilePosition
+	seq uint64
+}
+
+// NewPipeline returns a Pipeline parsing and counting lines as configured by
+// cfg, on the time of clock. A nil clock is the wall clock.
+func NewPipeline(cfg *Config, clock Clock) *Pipeline {
+	if clock == nil {
+		clock = WallClock{}
+	}
+	size := cfg.QueueSize
+	policy, _ := ParseOverflowPolicy(cfg.OverflowPolicy)
+	// Replays hand the lines over one at a time, so that every line is
+	// counted before the replay clock moves past it.
+	if cfg.Replay {
+		size, policy = 0, OverflowBlock
+	}
+	p := &Pipeline{
+		config:    cfg,
+		clock:     clock,
+		pause:     make(chan struct{}),
+		positions: make(map[string]queuedPosition),
+	}
+	p.queue = newQueue[Line](size, policy, cfg.SampleRate, &p.dropped)
+	p.lines = newQueue[string](cfg.QueueSize, OverflowDropOldest, 1, nil)
+	return p
+}
+
+// Dropped returns the number of lines dropped by the overflow policy.
+func (p *Pipeline) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Positions returns the positions up to which the log files were read and
+// their lines counted or rejected.
+func (p *Pipeline) Positions() map[string]FilePosition {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := make(map[string]FilePosition, len(p.positions))
+	for file, pos := range p.positions {
+		files[file] = pos.FilePosition
+	}
+	return files
+}
+
+// advance records the position of a line of a log file once it is done with,
+// whether it was counted, rejected or held no record. Of the lines of a file,
+// the position of the last one queued is kept, even across a rotation. Since
+// the queue hands the lines over in order and checkpoints wait for the lines
+// taken off it, no line before that position is still queued or parsed when
+// it is saved.
+func (p *Pipeline) advance(line Line) {
+	if line.Position.Inode == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if saved, ok := p.positions[line.Source]; !ok || saved.seq < line.seq {
+		p.positions[line.Source] = queuedPosition{FilePosition: line.Position, seq: line.seq}
+	}
+}
+
+// checkpoint waits for the lines taken off the queue, and calls Checkpoint
+// with the positions read before the workers take any other line.
+func (p *Pipeline) checkpoint() {
+	close(p.pause)
+	p.checkpointMu.Lock()
+	defer p.checkpointMu.Unlock()
+
+	p.pause = make(chan struct{})
+	p.Checkpoint(p.Positions())
+}
+
+// next takes the next line off the queue, and returns with checkpointMu held
+// for reading unless the queue is closed. Waiting for a line, it lets the
+// checkpoints through.
+func (p *Pipeline) next() (Line, bool) {
+	for {
+		p.checkpointMu.RLock()
+		select {
+		case line, ok := <-p.queue.c:
+			if !ok {
+				p.checkpointMu.RUnlock()
+			}
+			return line, ok
+		case <-p.pause:
+			p.checkpointMu.RUnlock()
+		}
+	}
+}
+
+// workers returns the number of parser workers. Replays and parsers keeping
+// state between lines need the lines parsed in order, by a single worker.
+func (p *Pipeline) workers() int {
+	if p.config.ParserWorkers <= 1 || p.config.Replay {
+		return 1
+	}
+	if statefulFormat(p.config) {
+		return 1
+	}
+	return p.config.ParserWorkers
+}
+
+// Open opens the dead letter file and every source.
+func (p *Pipeline) Open(ctx context.Context) error {
+	if _, err := NewParser(p.config); err != nil {
+		log.Println(err)
+		return err
+	}
+	if _, err := ParseOverflowPolicy(p.config.OverflowPolicy); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if p.config.DeadLetterFile != "" {
+		f, err := os.OpenFile(p.config.DeadLetterFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		p.dl = &deadLetter{file: f}
+	}
+
+	for _, src := range p.Sources {
+		if err := src.Open(ctx); err != nil {
+			log.Println(err)
+			p.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// close releases the dead letter file. The sources stop with their context.
+func (p *Pipeline) close() {
+	if p.dl != nil {
+		p.dl.file.Close()
+		p.dl = nil
+	}
+}
+
+// Run runs the opened pipeline until ctx is done.
+func (p *Pipeline) Run(ctx context.Context) {
+	defer p.close()
+
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		p.read(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		p.tail(ctx)
+	}()
+
+	p.process(ctx)
+}
+
+// read parses the lines of every source until they end or ctx is done.
+func (p *Pipeline) read(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < p.workers(); i++ {
+		shard := p.Aggregator.NewShard()
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			p.parse(ctx, shard)
+		}()
+	}
+
+	// Replays read the sources once, one after another, on a clock driven by
+	// the log timestamps.
+	replay, ok := p.clock.(*ReplayClock)
+	if ok && p.config.Replay {
+		for _, src := range p.Sources {
+			p.readSource(ctx, src)
+		}
+	} else {
+		var readers sync.WaitGroup
+		for _, src := range p.Sources {
+			readers.Add(1)
+			go func(src Source) {
+				defer readers.Done()
+				p.readSource(ctx, src)
+			}(src)
+		}
+		readers.Wait()
+	}
+	close(p.queue.c)
+	workers.Wait()
+
+	// Let the last refresh and alert windows of the replay close.
+	if ok && p.config.Replay && !replay.Now().IsZero() && ctx.Err() == nil {
+		replay.Advance(replay.Now().Add(time.Duration(p.config.AlertInterval+p.config.AllowedLateness) * time.Second))
+	}
+}
+
+// readSource runs src and queues its lines for the parser workers.
+func (p *Pipeline) readSource(ctx context.Context, src Source) {
+	lines := make(chan Line)
+	go func() {
+		defer close(lines)
+		src.Run(ctx, lines)
+	}()
+	for line := range lines {
+		line.seq = p.queued.Add(1)
+		if !p.queue.push(ctx, line) {
+			return
+		}
+	}
+}
+
+// parse parses the queued lines into shard, with one parser per source since
+// parsers like the W3C one keep state between the lines of a log.
+func (p *Pipeline) parse(ctx context.Context, shard Shard) {
+	parsers := make(map[string]Parser)
+	replay, _ := p.clock.(*ReplayClock)
+	// Parsers which parse into a CommonLog of the caller reuse this one.
+	var item CommonLog
+
+	for {
+		line, ok := p.next()
+		if !ok {
+			return
+		}
+		statitem := p.parseLine(shard, parsers, &item, line)
+		p.advance(line)
+		p.checkpointMu.RUnlock()
+		if statitem == nil {
+			continue
+		}
+
+		// Advancing the replay clock waits for the windows it closes, and
+		// for the checkpoints with them, so it is done out of checkpointMu.
+		if replay != nil {
+			replay.Advance(statitem.Time)
+		}
+		if !p.lines.push(ctx, line.Text) {
+			return
+		}
+	}
+}
+
+// parseLine parses line into shard, and returns the line counted, or nil when
+// it was rejected or held no record.
+func (p *Pipeline) parseLine(shard Shard, parsers map[string]Parser, item *CommonLog, line Line) *CommonLog {
+	if line.Err != nil {
+		p.dl.WriteLine(line.Text)
+		shard.AddError(NewParseError(line.Text, line.Err))
+		return nil
+	}
+
+	parser, ok := parsers[line.Source]
+	if !ok {
+		parser, _ = NewParser(p.config)
+		parsers[line.Source] = parser
+	}
+	var statitem *CommonLog
+	var err error
+	if into, ok := parser.(IntoParser); ok {
+		statitem = item
+		err = into.ParseInto(line.Text, statitem)
+	} else {
+		statitem, err = parser.Parse(line.Text)
+	}
+	if err == ErrSkipLine {
+		return nil
+	}
+	if err != nil {
+		p.dl.WriteLine(line.Text)
+		shard.AddError(NewParseError(line.Text, err))
+		return nil
+	}
+
+	statitem.Source = line.Source
+	statitem.Position = line.Position
+	// Lines without a date take the date of their envelope, or are
+	// dated when they are read.
+	if statitem.Time.IsZero() {
+		statitem.Time = line.Time
+	}
+	if statitem.Time.IsZero() {
+		statitem.Time = p.clock.Now()
+	}
+	shard.Add(*statitem)
+	return statitem
+}
+
+// process closes the refresh and alert windows, handing the results to the
+// sinks, until ctx is done.
+func (p *Pipeline) process(ctx context.Context) {
+	// Refresh windows and alert evaluations follow the pipeline clock, which
+	// is driven by the log timestamps when replaying. The alert window slides
+	// by one second at every evaluation.
+	alertTicker := p.clock.Tick(time.Second)
+	refreshTicker := p.clock.Tick(time.Duration(p.config.RefreshInterval) * time.Second)
+	if replay, ok := p.clock.(*ReplayClock); ok {
+		replay.Start()
+	}
+
+	var checkpointTicker <-chan time.Time
+	if p.Checkpoint != nil && p.CheckpointInterval > 0 {
+		ticker := time.NewTicker(p.CheckpointInterval)
+		defer ticker.Stop()
+		checkpointTicker = ticker.C
+	}
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			if p.Checkpoint != nil {
+				p.checkpoint()
+			}
+			return
+
+		case <-checkpointTicker:
+			p.checkpoint()
+
+		case now := <-refreshTicker:
+			snap := p.Aggregator.Refresh(now)
+			snap.Dropped = p.Dropped()
+			for _, sink := range p.Sinks {
+				sink.Refresh(snap)
+			}
+
+		case now := <-alertTicker:
+			alerts, snap := p.Aggregator.Alert(now)
+			snap.Dropped = p.Dropped()
+			for _, sink := range p.Sinks {
+				for _, alert := range alerts {
+					sink.Alert(alert)
+				}
+				sink.Average(snap)
+			}
+		}
+	}
+}
+
+// tail hands the lines counted to the sinks until ctx is done.
+func (p *Pipeline) tail(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-p.lines.c:
+			for _, sink := range p.Sinks {
+				sink.Line(line)
+			}
+		}
+	}
+}
+
+// send sends v on c, unless ctx is done first.
+func send[T any](ctx context.Context, c chan<- T, v T) bool {
+	select {
+	case c <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,238 @@
+package logwatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PipelineSuite struct{}
+
+var _ = Suite(&PipelineSuite{})
+
+// sliceSource sends its lines, then ends.
+type sliceSource []Line
+
+func (src sliceSource) Open(ctx context.Context) error {
+	return nil
+}
+
+func (src sliceSource) Run(ctx context.Context, out chan<- Line) {
+	for _, line := range src {
+		if !send(ctx, out, line) {
+			return
+		}
+	}
+}
+
+// chanAggregator hands the lines of its shards over to the test.
+type chanAggregator struct {
+	events chan CommonLog
+	errs   chan ParseError
+}
+
+func (agg *chanAggregator) NewShard() Shard {
+	return agg
+}
+
+func (agg *chanAggregator) Add(item CommonLog) {
+	agg.events <- item
+}
+
+func (agg *chanAggregator) AddError(perr ParseError) {
+	agg.errs <- perr
+}
+
+func (agg *chanAggregator) Refresh(now time.Time) Snapshot {
+	return Snapshot{Time: now}
+}
+
+func (agg *chanAggregator) Alert(now time.Time) ([]Alert, Snapshot) {
+	return nil, Snapshot{Time: now}
+}
+
+// countAggregator counts the lines by source in a single shard, and raises
+// an alert on every alert window.
+type countAggregator struct {
+	mu     sync.Mutex
+	hits   map[string]int
+	errors []string
+}
+
+func (agg *countAggregator) NewShard() Shard {
+	return agg
+}
+
+func (agg *countAggregator) Add(item CommonLog) {
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	agg.hits[item.Source]++
+}
+
+func (agg *countAggregator) AddError(perr ParseError) {
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	agg.errors = append(agg.errors, perr.Reason)
+}
+
+func (agg *countAggregator) Refresh(now time.Time) Snapshot {
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	return Snapshot{Time: now, Total: StatsTotal{TotalHits: agg.hits["a"] + agg.hits["b"]}}
+}
+
+func (agg *countAggregator) Alert(now time.Time) ([]Alert, Snapshot) {
+	return []Alert{{Time: now, Message: "alert"}}, agg.Refresh(now)
+}
+
+func (s *PipelineSuite) TestPipelineRunOk(c *C) {
+	cfg := &Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 1}
+	line := `10.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /a HTTP/1.1" 200 512`
+	agg := &countAggregator{hits: make(map[string]int)}
+	refreshC := make(chan Snapshot, 10)
+	alertC := make(chan Alert, 10)
+	lines := 0
+
+	p := NewPipeline(cfg, nil)
+	p.Sources = []Source{
+		sliceSource{
+			{Source: "a", Text: line, Position: FilePosition{Inode: 1, Offset: 70}},
+			{Source: "a", Text: "nope", Position: FilePosition{Inode: 1, Offset: 75}},
+		},
+		sliceSource{
+			{Source: "b", Text: line, Position: FilePosition{Inode: 2, Offset: 70}},
+			{Source: "b", Text: line, Position: FilePosition{Inode: 2, Offset: 140}, Err: errors.New("bad envelope")},
+		},
+	}
+	p.Aggregator = agg
+	var files map[string]FilePosition
+	p.Checkpoint = func(read map[string]FilePosition) { files = read }
+	p.Sinks = []Sink{SinkFuncs{
+		OnLine:    func(string) { lines++ },
+		OnRefresh: func(snap Snapshot) { refreshC <- snap },
+		OnAlert:   func(alert Alert) { alertC <- alert },
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Assert(p.Open(ctx), IsNil)
+	done := make(chan bool)
+	go func() {
+		p.Run(ctx)
+		done <- true
+	}()
+
+	select {
+	case snap := <-refreshC:
+		c.Assert(snap.Total.TotalHits, Equals, 2)
+	case <-time.After(3 * time.Second):
+		c.Fatal("no refresh")
+	}
+	select {
+	case alert := <-alertC:
+		c.Assert(alert.Message, Equals, "alert")
+	case <-time.After(3 * time.Second):
+		c.Fatal("no alert")
+	}
+	cancel()
+	<-done
+
+	c.Assert(agg.hits, DeepEquals, map[string]int{"a": 1, "b": 1})
+	c.Assert(agg.errors, HasLen, 2)
+	c.Assert(lines, Equals, 2)
+	// The rejected lines are read past like the counted ones.
+	c.Assert(files, DeepEquals, map[string]FilePosition{
+		"a": {Inode: 1, Offset: 75},
+		"b": {Inode: 2, Offset: 140},
+	})
+}
+
+func (s *PipelineSuite) TestNewSources(c *C) {
+	cfg := &Config{
+		LogFile:      []string{"/var/log/a.log", StdinLogFile, "/var/log/*.log"},
+		SyslogListen: []string{"udp://127.0.0.1:0"},
+		GlobInterval: 10,
+	}
+	sources := NewSources(cfg, nil)
+	c.Assert(sources, HasLen, 3)
+	c.Assert(sources[0], DeepEquals, &FileSource{
+		Patterns:     []string{"/var/log/a.log", "/var/log/*.log"},
+		Follow:       true,
+		GlobInterval: 10 * time.Second,
+	})
+	c.Assert(sources[1], FitsTypeOf, &StdinSource{})
+	c.Assert(sources[2], DeepEquals, &SyslogSource{Listen: "udp://127.0.0.1:0"})
+
+	cfg.Replay = true
+	cfg.LogFile = []string{StdinLogFile}
+	cfg.SyslogListen = nil
+	c.Assert(NewSources(cfg, nil), DeepEquals, []Source{&StdinSource{}})
+}
+
+func (s *PipelineSuite) TestStatsAggregatorShards(c *C) {
+	dir := c.MkDir()
+	cfg := &Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 20, StateFile: dir + "/state.json"}
+	lw, err := New(cfg)
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+
+	now := time.Now()
+	first, second := agg.NewShard(), agg.NewShard()
+	first.Add(CommonLog{Request: "/a/1", Status: 200, Source: "a.log", Time: now.Add(-time.Second),
+		Position: FilePosition{Inode: 1, Offset: 100}})
+	second.Add(CommonLog{Request: "/a/2", Status: 500, Source: "a.log", Time: now.Add(-time.Second),
+		Position: FilePosition{Inode: 1, Offset: 200}})
+	second.Add(CommonLog{Request: "/b", Status: 200, Source: "b.log", Time: now.Add(time.Second)})
+	first.AddError(NewParseError("nope", ErrNoMatch))
+
+	snap := agg.Refresh(now)
+	c.Assert(snap.Total.TotalHits, Equals, 2)
+	c.Assert(snap.Total.Total5xx, Equals, 1)
+	c.Assert(snap.Total.TopSections, DeepEquals, map[string]int{"/a": 2})
+	c.Assert(snap.Total.TopStatus, DeepEquals, map[string]int{"200": 1, "500": 1})
+	c.Assert(snap.Sources["a.log"].TotalHits, Equals, 2)
+	c.Assert(snap.Errors.TotalErrors, Equals, 1)
+
+	// The checkpoint keeps the positions read, and the line of the next
+	// window.
+	agg.checkpoint(map[string]FilePosition{"a.log": {Inode: 1, Offset: 200}})
+	state, err := LoadState(cfg.StateFile)
+	c.Assert(err, IsNil)
+	c.Assert(state.Files["a.log"], Equals, FilePosition{Inode: 1, Offset: 200})
+	c.Assert(state.Pending, HasLen, 1)
+	c.Assert(state.Pending[0].Request, Equals, "/b")
+}
+
+func (s *PipelineSuite) TestStatsAggregatorWatermark(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 20})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+
+	// A line of the second of the refresh, read just after it, is counted by
+	// the next one.
+	now := time.Date(2016, 5, 11, 22, 0, 10, 300*int(time.Millisecond), time.UTC)
+	agg.Refresh(now)
+	c.Assert(lw.Watermark, Equals, time.Date(2016, 5, 11, 22, 0, 10, 0, time.UTC))
+	shard.Add(CommonLog{Request: "/a", Status: 200, Source: "a.log", Time: now.Truncate(time.Second)})
+
+	snap := agg.Refresh(now.Add(10 * time.Second))
+	c.Assert(snap.Total.TotalHits, Equals, 1)
+	c.Assert(lw.TotalLate, Equals, 0)
+}
+
+func (s *PipelineSuite) TestPipelineWorkers(c *C) {
+	p := NewPipeline(&Config{LogFormat: "clf", ParserWorkers: 4}, nil)
+	c.Assert(p.workers(), Equals, 4)
+	p = NewPipeline(&Config{LogFormat: "clf", ParserWorkers: 4, Replay: true}, nil)
+	c.Assert(p.workers(), Equals, 1)
+	p = NewPipeline(&Config{LogFormat: "w3c", ParserWorkers: 4}, nil)
+	c.Assert(p.workers(), Equals, 1)
+	p = NewPipeline(&Config{LogFormat: "clf"}, nil)
+	c.Assert(p.workers(), Equals, 1)
+}
@@ -0,0 +1,173 @@
+package logwatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// defaultSlackTemplate is the text of the Slack messages, rendered with the
+// AlertPayload of the alert.
+const defaultSlackTemplate = `{{if eq .State "firing"}}:rotating_light:{{else}}:white_check_mark:{{end}} ` +
+	`*{{.Name}}* {{.State}}{{if .Severity}} [{{.Severity}}]{{end}}{{if .Flapping}}, flapping{{end}}`
+
+// Colors of the Slack attachments.
+const (
+	slackFiring    = "danger"
+	slackRecovered = "good"
+	slackFlapping  = "warning"
+)
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Text     string       `json:"text"`
+	Fallback string       `json:"fallback"`
+	Fields   []slackField `json:"fields,omitempty"`
+	Ts       int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// NewSlackNotifier returns a WebhookNotifier posting messages to target, a
+// Slack or Mattermost incoming webhook. The text of the messages is text, a
+// text/template rendered with the AlertPayload of the alert, or
+// defaultSlackTemplate when empty, and the message of the alert is attached.
+func NewSlackNotifier(target, text string, timeout time.Duration, retries int) (*WebhookNotifier, error) {
+	if text == "" {
+		text = defaultSlackTemplate
+	}
+	tmpl, err := template.New("slack").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Slack template: %w", err)
+	}
+	webhook, err := NewWebhookNotifier(target, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Encode = func(alert Alert) ([]byte, error) {
+		return encodeSlack(tmpl, alert)
+	}
+	return webhook, nil
+}
+
+func encodeSlack(tmpl *template.Template, alert Alert) ([]byte, error) {
+	payload := alert.Payload()
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, payload); err != nil {
+		return nil, err
+	}
+
+	attachment := slackAttachment{
+		Color:    slackFiring,
+		Text:     alert.Message,
+		Fallback: alert.Message,
+		Ts:       alert.Time.Unix(),
+	}
+	switch {
+	case alert.Flapping:
+		attachment.Color = slackFlapping
+	case alert.Recovered:
+		attachment.Color = slackRecovered
+	}
+	if alert.Severity != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Severity", Value: alert.Severity, Short: true})
+	}
+	if alert.Rule != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Value", Value: formatValue(alert.Value), Short: true})
+	} else {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Average hits", Value: fmt.Sprint(alert.AvgHits), Short: true})
+	}
+	if alert.Source != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Source", Value: alert.Source, Short: true})
+	}
+	return json.Marshal(slackMessage{Text: text.String(), Attachments: []slackAttachment{attachment}})
+}
+
+// pagerDutyEvent is an event of the PagerDuty Events API v2. Resolve events
+// only need the dedup key.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string       `json:"summary"`
+	Source        string       `json:"source"`
+	Severity      string       `json:"severity"`
+	Timestamp     string       `json:"timestamp"`
+	Component     string       `json:"component"`
+	CustomDetails AlertPayload `json:"custom_details"`
+}
+
+// maxPagerDutySummary is the length PagerDuty truncates summaries to.
+var maxPagerDutySummary = 1024
+
+// NewPagerDutyNotifier returns a WebhookNotifier sending the alerts to the
+// PagerDuty Events API v2 at target, with routingKey the integration key of
+// the service. Firing alerts trigger an incident and recovered ones resolve
+// it, both identified by the DedupKey of the alert.
+func NewPagerDutyNotifier(target, routingKey string, timeout time.Duration, retries int) (*WebhookNotifier, error) {
+	webhook, err := NewWebhookNotifier(target, timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Encode = func(alert Alert) ([]byte, error) {
+		return encodePagerDuty(routingKey, alert)
+	}
+	return webhook, nil
+}
+
+func encodePagerDuty(routingKey string, alert Alert) ([]byte, error) {
+	event := pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "resolve",
+		DedupKey:    alert.DedupKey(),
+	}
+	if !alert.Recovered {
+		event.EventAction = "trigger"
+		summary := alert.Message
+		if len(summary) > maxPagerDutySummary {
+			summary = summary[:maxPagerDutySummary]
+		}
+		source := alert.Source
+		if source == "" {
+			source = "logwatcher"
+		}
+		severity := alert.Severity
+		if severity == "" {
+			severity = "warning"
+		}
+		event.Payload = &pagerDutyPayload{
+			Summary:       summary,
+			Source:        source,
+			Severity:      severity,
+			Timestamp:     alert.Time.Format(time.RFC3339),
+			Component:     "logwatcher",
+			CustomDetails: alert.Payload(),
+		}
+	}
+	return json.Marshal(event)
+}
+
+// DedupKey returns the key identifying the incidents of the alert, made of
+// its name and of the source of its rule. The busiest source of the high
+// traffic alert is left out, as it changes between firing and recovering.
+func (a Alert) DedupKey() string {
+	if a.Rule != "" && a.Source != "" {
+		return "logwatcher/" + a.Name() + "/" + a.Source
+	}
+	return "logwatcher/" + a.Name()
+}
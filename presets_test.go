@@ -0,0 +1,138 @@
+package logwatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PresetsSuite struct{}
+
+var _ = Suite(&PresetsSuite{})
+
+func (s *PresetsSuite) TestSlackNotifier(c *C) {
+	ws := newWebhookServer()
+	defer ws.Close()
+	slack, err := NewSlackNotifier(ws.URL, "", time.Second, 0)
+	c.Assert(err, IsNil)
+	highTraffic := Alert{Time: windowStart, Recovered: true, AvgHits: 9, Source: "web-1",
+		Message: "Low traffic generated a recover - average hits = 9, triggered at May 11 22:00:00.000"}
+	c.Assert(slack.Notify(context.Background(), testAlert), IsNil)
+	c.Assert(slack.Notify(context.Background(), highTraffic), IsNil)
+
+	posted := ws.posted()
+	c.Assert(posted, HasLen, 2)
+	var message slackMessage
+	c.Assert(json.Unmarshal(posted[0], &message), IsNil)
+	c.Assert(message, DeepEquals, slackMessage{
+		Text: ":rotating_light: *errors* firing [critical]",
+		Attachments: []slackAttachment{{
+			Color:    "danger",
+			Text:     testAlert.Message,
+			Fallback: testAlert.Message,
+			Fields: []slackField{
+				{Title: "Severity", Value: "critical", Short: true},
+				{Title: "Value", Value: "3", Short: true},
+			},
+			Ts: windowStart.Unix(),
+		}},
+	})
+	message = slackMessage{}
+	c.Assert(json.Unmarshal(posted[1], &message), IsNil)
+	c.Assert(message.Text, Equals, ":white_check_mark: *high_traffic* recovered")
+	c.Assert(message.Attachments[0].Color, Equals, "good")
+	c.Assert(message.Attachments[0].Fields, DeepEquals, []slackField{
+		{Title: "Average hits", Value: "9", Short: true},
+		{Title: "Source", Value: "web-1", Short: true},
+	})
+
+	slack, err = NewSlackNotifier(ws.URL, "{{.Name}} is {{.State}} at {{.Time.Format \"15:04\"}}", time.Second, 0)
+	c.Assert(err, IsNil)
+	flapping := testAlert
+	flapping.Flapping = true
+	c.Assert(slack.Notify(context.Background(), flapping), IsNil)
+	message = slackMessage{}
+	c.Assert(json.Unmarshal(ws.posted()[2], &message), IsNil)
+	c.Assert(message.Text, Equals, "errors is firing at 22:00")
+	c.Assert(message.Attachments[0].Color, Equals, "warning")
+
+	_, err = NewSlackNotifier(ws.URL, "{{.Name", time.Second, 0)
+	c.Assert(err, ErrorMatches, "invalid Slack template: .*")
+	slack, _ = NewSlackNotifier(ws.URL, "{{.Nope}}", time.Second, 0)
+	c.Assert(slack.Notify(context.Background(), testAlert), ErrorMatches, `.*can't evaluate field Nope .*`)
+}
+
+func (s *PresetsSuite) TestPagerDutyNotifier(c *C) {
+	ws := newWebhookServer(http.StatusTooManyRequests)
+	defer ws.Close()
+	pagerDuty, err := NewPagerDutyNotifier(ws.URL, "R0UT1NGK3Y", time.Second, 1)
+	c.Assert(err, IsNil)
+	pagerDuty.Backoff = time.Millisecond
+
+	firing := testAlert
+	firing.Source = "web-1"
+	recovered := firing
+	recovered.Recovered = true
+	c.Assert(pagerDuty.Notify(context.Background(), firing), IsNil)
+	c.Assert(pagerDuty.Notify(context.Background(), recovered), IsNil)
+
+	// The trigger is retried once after a 429.
+	posted := ws.posted()
+	c.Assert(posted, HasLen, 3)
+	var trigger, resolve pagerDutyEvent
+	c.Assert(json.Unmarshal(posted[1], &trigger), IsNil)
+	c.Assert(json.Unmarshal(posted[2], &resolve), IsNil)
+	c.Assert(trigger, DeepEquals, pagerDutyEvent{
+		RoutingKey:  "R0UT1NGK3Y",
+		EventAction: "trigger",
+		DedupKey:    "logwatcher/errors/web-1",
+		Payload: &pagerDutyPayload{
+			Summary:       firing.Message,
+			Source:        "web-1",
+			Severity:      "critical",
+			Timestamp:     "2016-05-11T22:00:00Z",
+			Component:     "logwatcher",
+			CustomDetails: firing.Payload(),
+		},
+	})
+	c.Assert(resolve, DeepEquals, pagerDutyEvent{
+		RoutingKey:  "R0UT1NGK3Y",
+		EventAction: "resolve",
+		DedupKey:    "logwatcher/errors/web-1",
+	})
+
+	// The busiest source changing does not change the incident.
+	highTraffic := Alert{Time: windowStart, AvgHits: 20, Source: "web-1", Message: "High traffic generated an alert"}
+	c.Assert(highTraffic.DedupKey(), Equals, "logwatcher/high_traffic")
+	data, err := encodePagerDuty("R0UT1NGK3Y", highTraffic)
+	c.Assert(err, IsNil)
+	c.Assert(json.Unmarshal(data, &trigger), IsNil)
+	c.Assert(trigger.Payload.Severity, Equals, "warning")
+	c.Assert(trigger.Payload.Source, Equals, "web-1")
+}
+
+func (s *PresetsSuite) TestNewNotifiers(c *C) {
+	notifiers, err := NewNotifiers(&Config{})
+	c.Assert(err, IsNil)
+	c.Assert(notifiers, HasLen, 0)
+
+	notifiers, err = NewNotifiers(&Config{
+		NotifyWebhook:      []string{"http://localhost:8080/hook"},
+		NotifySlack:        []string{"https://hooks.slack.com/services/T0/B0/X", "https://chat.example.com/hooks/xyz"},
+		NotifyPagerDuty:    "R0UT1NGK3Y",
+		NotifyPagerDutyURL: "https://events.pagerduty.com/v2/enqueue",
+		NotifyExec:         []string{"true"},
+		NotifyTimeout:      10,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(notifiers, HasLen, 5)
+	c.Assert(notifiers[3].(*WebhookNotifier).URL, Equals, "https://events.pagerduty.com/v2/enqueue")
+
+	_, err = NewNotifiers(&Config{NotifySlack: []string{"http://localhost/hook"}})
+	c.Assert(err, ErrorMatches, "the notify timeout must be positive, .*")
+	_, err = NewNotifiers(&Config{NotifyPagerDuty: "R0UT1NGK3Y", NotifyTimeout: 10})
+	c.Assert(err, ErrorMatches, `invalid webhook URL ""`)
+}
@@ -0,0 +1,104 @@
+package logwatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy tells what a full queue does with a new line.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, which slows the sources down.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest line of the queue.
+	OverflowDropOldest
+	// OverflowSample keeps one new line out of the sample rate, dropping the
+	// oldest line of the queue for it, and drops the others.
+	OverflowSample
+)
+
+// ParseOverflowPolicy parses the --overflow-policy values block, drop-oldest
+// and sample. An empty policy blocks.
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch policy {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "sample":
+		return OverflowSample, nil
+	}
+	return OverflowBlock, fmt.Errorf("invalid overflow policy %q, expected block, drop-oldest or sample", policy)
+}
+
+// queue is a bounded queue between the parsers and the aggregator, which
+// applies its overflow policy when full and counts the items dropped. Queues
+// which block may have no room at all, handing their items over one at a time.
+type queue[T any] struct {
+	c       chan T
+	policy  OverflowPolicy
+	rate    int
+	dropped *atomic.Uint64
+
+	mu        sync.Mutex
+	overflows int
+}
+
+func newQueue[T any](size int, policy OverflowPolicy, rate int, dropped *atomic.Uint64) *queue[T] {
+	if size < 1 && policy != OverflowBlock {
+		size = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+	if rate < 1 {
+		rate = 1
+	}
+	return &queue[T]{c: make(chan T, size), policy: policy, rate: rate, dropped: dropped}
+}
+
+// push adds v to the queue, and tells whether ctx is not done.
+func (q *queue[T]) push(ctx context.Context, v T) bool {
+	if q.policy == OverflowBlock {
+		return send(ctx, q.c, v)
+	}
+	select {
+	case q.c <- v:
+		return true
+	default:
+	}
+
+	// Producers make room one at a time, so that each overflow drops a
+	// single item.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.policy == OverflowSample {
+		q.overflows++
+		if q.overflows%q.rate != 0 {
+			q.drop()
+			return ctx.Err() == nil
+		}
+	}
+	for {
+		select {
+		case q.c <- v:
+			return true
+		default:
+		}
+		select {
+		case <-q.c:
+			q.drop()
+		default:
+		}
+	}
+}
+
+func (q *queue[T]) drop() {
+	if q.dropped != nil {
+		q.dropped.Add(1)
+	}
+}
@@ -0,0 +1,147 @@
+package logwatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type QueueSuite struct{}
+
+var _ = Suite(&QueueSuite{})
+
+func drain(q *queue[int]) []int {
+	items := make([]int, 0)
+	for len(q.c) > 0 {
+		items = append(items, <-q.c)
+	}
+	return items
+}
+
+func (s *QueueSuite) TestParseOverflowPolicy(c *C) {
+	for value, policy := range map[string]OverflowPolicy{
+		"":            OverflowBlock,
+		"block":       OverflowBlock,
+		"drop-oldest": OverflowDropOldest,
+		"sample":      OverflowSample,
+	} {
+		p, err := ParseOverflowPolicy(value)
+		c.Assert(err, IsNil)
+		c.Assert(p, Equals, policy)
+	}
+	_, err := ParseOverflowPolicy("drop-newest")
+	c.Assert(err, ErrorMatches, `invalid overflow policy "drop-newest", expected block, drop-oldest or sample`)
+}
+
+func (s *QueueSuite) TestQueueBlock(c *C) {
+	var dropped atomic.Uint64
+	q := newQueue[int](2, OverflowBlock, 1, &dropped)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Assert(q.push(ctx, 1), Equals, true)
+	c.Assert(q.push(ctx, 2), Equals, true)
+
+	// A full queue waits, until ctx is done.
+	cancel()
+	c.Assert(q.push(ctx, 3), Equals, false)
+	c.Assert(drain(q), DeepEquals, []int{1, 2})
+	c.Assert(dropped.Load(), Equals, uint64(0))
+}
+
+func (s *QueueSuite) TestQueueDropOldest(c *C) {
+	var dropped atomic.Uint64
+	q := newQueue[int](3, OverflowDropOldest, 1, &dropped)
+	for i := 1; i <= 10; i++ {
+		c.Assert(q.push(context.Background(), i), Equals, true)
+	}
+	c.Assert(drain(q), DeepEquals, []int{8, 9, 10})
+	c.Assert(dropped.Load(), Equals, uint64(7))
+}
+
+func (s *QueueSuite) TestQueueSample(c *C) {
+	var dropped atomic.Uint64
+	q := newQueue[int](2, OverflowSample, 3, &dropped)
+	for i := 1; i <= 11; i++ {
+		c.Assert(q.push(context.Background(), i), Equals, true)
+	}
+	// Of the 9 lines overflowing, one out of 3 takes the place of the
+	// oldest line.
+	c.Assert(drain(q), DeepEquals, []int{8, 11})
+	c.Assert(dropped.Load(), Equals, uint64(9))
+}
+
+// blockingShard waits for release on its first line.
+type blockingShard struct {
+	chanAggregator
+	started chan bool
+	release chan bool
+	once    sync.Once
+}
+
+func (agg *blockingShard) NewShard() Shard {
+	return agg
+}
+
+func (agg *blockingShard) Add(item CommonLog) {
+	agg.once.Do(func() {
+		agg.started <- true
+		<-agg.release
+	})
+	agg.events <- item
+}
+
+// startedSource sends its first line, and the others once the shard started
+// counting it.
+type startedSource struct {
+	sliceSource
+	started chan bool
+}
+
+func (src startedSource) Run(ctx context.Context, out chan<- Line) {
+	out <- src.sliceSource[0]
+	<-src.started
+	src.sliceSource[1:].Run(ctx, out)
+}
+
+func (s *QueueSuite) TestPipelineDropped(c *C) {
+	cfg := &Config{LogFormat: "clf", QueueSize: 2, OverflowPolicy: "drop-oldest"}
+	line := `10.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /%d HTTP/1.1" 200 512`
+	lines := make(sliceSource, 0)
+	for i := 0; i < 10; i++ {
+		lines = append(lines, Line{Source: "a", Text: fmt.Sprintf(line, i)})
+	}
+	started := make(chan bool)
+	agg := &blockingShard{
+		chanAggregator: chanAggregator{events: make(chan CommonLog, 10)},
+		started:        started,
+		release:        make(chan bool),
+	}
+	p := NewPipeline(cfg, nil)
+	p.Sources = []Source{startedSource{sliceSource: lines, started: started}}
+	p.Aggregator = agg
+	c.Assert(p.Open(context.Background()), IsNil)
+
+	// While the worker is stuck on the first line, the source is still read
+	// to its end.
+	done := make(chan bool)
+	go func() {
+		p.read(context.Background())
+		done <- true
+	}()
+	for len(p.queue.c) < 2 || p.Dropped() < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	close(agg.release)
+	<-done
+
+	c.Assert(p.Dropped(), Equals, uint64(7))
+	c.Assert((<-agg.events).Request, Equals, "/0")
+	c.Assert((<-agg.events).Request, Equals, "/8")
+	c.Assert((<-agg.events).Request, Equals, "/9")
+
+	p = NewPipeline(&Config{LogFormat: "clf", OverflowPolicy: "nope"}, nil)
+	c.Assert(p.Open(context.Background()), ErrorMatches, "invalid overflow policy .*")
+}
@@ -0,0 +1,369 @@
+package logwatcher
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report is the summary of whole log files written by the batch report mode.
+type Report struct {
+	Files       []string         `json:"files"`
+	Start       time.Time        `json:"start"`
+	End         time.Time        `json:"end"`
+	TotalHits   int              `json:"total_hits"`
+	Total2xx    int              `json:"total_2xx"`
+	Total3xx    int              `json:"total_3xx"`
+	Total4xx    int              `json:"total_4xx"`
+	Total5xx    int              `json:"total_5xx"`
+	TotalLate   int              `json:"total_late"`
+	TotalErrors int              `json:"total_errors"`
+	TopSections map[string]int   `json:"top_sections"`
+	TopStatus   map[string]int   `json:"top_status"`
+	Intervals   []ReportInterval `json:"intervals"`
+	Alerts      []ReportAlert    `json:"alerts"`
+}
+
+// ReportInterval holds the averages of one alert window.
+type ReportInterval struct {
+	End     time.Time `json:"end"`
+	AvgHits int       `json:"avg_hits"`
+	Avg2xx  int       `json:"avg_2xx"`
+	Avg3xx  int       `json:"avg_3xx"`
+	Avg4xx  int       `json:"avg_4xx"`
+	Avg5xx  int       `json:"avg_5xx"`
+	Alert   bool      `json:"alert"`
+}
+
+// ReportAlert is an alert or recover message raised while reading the logs,
+// by the high traffic alert or by the alert rule Rule.
+type ReportAlert struct {
+	Time    time.Time `json:"time"`
+	Alert   bool      `json:"alert"`
+	AvgHits int       `json:"avg_hits"`
+	Rule    string    `json:"rule,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Report reads files from start to end without the console, running the same
+// refresh windows and alert evaluations as the dashboard, with its alert
+// rules, on the log timestamps, and returns their summary. Gzip and zstd
+// compressed files are read transparently, in the order of their first record.
+func (lw *Watcher) Report(files []string) (*Report, error) {
+	parser, err := NewParser(lw.Config)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	files = sortLogFiles(files, lw.Config)
+
+	if lw.StatsTotal == nil {
+		lw.StatsTotal = &StatsTotal{}
+	}
+	if lw.StatsAvg == nil {
+		lw.StatsAvg = &StatsAvg{}
+	}
+	if lw.StatsErrors == nil {
+		lw.StatsErrors = &StatsErrors{ErrorReasons: make(map[string]int)}
+	}
+	if lw.CollectionNum == 0 {
+		lw.CollectionNum = lw.AlertInterval / lw.RefreshInterval
+	}
+	clock := NewReplayClock(0)
+	clock.Start()
+	lw.Clock = clock
+
+	report := &Report{
+		Files:       files,
+		TopSections: make(map[string]int),
+		TopStatus:   make(map[string]int),
+		Intervals:   make([]ReportInterval, 0),
+		Alerts:      make([]ReportAlert, 0),
+	}
+
+	refresh := time.Duration(lw.RefreshInterval) * time.Second
+	interval := time.Duration(lw.AlertInterval) * time.Second
+	lateness := time.Duration(lw.AllowedLateness) * time.Second
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+	var nextRefresh, nextAlert, nextInterval time.Time
+
+	// flush closes the refresh windows and evaluates the alert windows up to
+	// now, as the refresh and alert tickers of the dashboard would.
+	flush := func(now time.Time) {
+		for !nextRefresh.After(now) || !nextAlert.After(now) {
+			if nextRefresh.After(nextAlert) {
+				clock.Advance(nextAlert)
+				alerts, snap := agg.Alert(nextAlert)
+				for _, alert := range alerts {
+					report.Alerts = append(report.Alerts, ReportAlert{
+						Time:    alert.Time,
+						Alert:   !alert.Recovered,
+						AvgHits: alert.AvgHits,
+						Rule:    alert.Rule,
+						Message: alert.Message,
+					})
+				}
+				if !nextAlert.Before(nextInterval) {
+					report.Intervals = append(report.Intervals, ReportInterval{
+						End:     nextAlert,
+						AvgHits: snap.Avg.AvgHits,
+						Avg2xx:  snap.Avg.Avg2xx,
+						Avg3xx:  snap.Avg.Avg3xx,
+						Avg4xx:  snap.Avg.Avg4xx,
+						Avg5xx:  snap.Avg.Avg5xx,
+						Alert:   snap.AlertState,
+					})
+					nextInterval = nextInterval.Add(interval)
+				}
+				nextAlert = nextAlert.Add(time.Second)
+				continue
+			}
+
+			clock.Advance(nextRefresh)
+			snap := agg.Refresh(nextRefresh)
+			for section, hits := range snap.Total.TopSections {
+				report.TopSections[section] += hits
+			}
+			for status, hits := range snap.Total.TopStatus {
+				report.TopStatus[status] += hits
+			}
+			nextRefresh = nextRefresh.Add(refresh)
+		}
+	}
+
+	for _, file := range files {
+		err := readLogFile(file, func(line string) {
+			statitem, err := parser.Parse(line)
+			if err == ErrSkipLine {
+				return
+			}
+			if err != nil {
+				shard.AddError(NewParseError(line, err))
+				return
+			}
+			if statitem.Time.IsZero() {
+				statitem.Time = clock.Now()
+				if statitem.Time.IsZero() {
+					statitem.Time = time.Now()
+				}
+			}
+
+			if report.Start.IsZero() {
+				report.Start = statitem.Time
+				start := statitem.Time.Truncate(time.Second)
+				nextRefresh = statitem.Time.Add(refresh)
+				nextAlert = start.Add(time.Second)
+				nextInterval = start.Add(interval)
+				clock.Advance(statitem.Time)
+			}
+			if statitem.Time.After(report.End) {
+				report.End = statitem.Time
+			}
+			flush(statitem.Time)
+			shard.Add(*statitem)
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	// Let the last refresh and alert windows close.
+	if !report.Start.IsZero() {
+		flush(report.End.Add(interval + lateness))
+	}
+
+	report.TotalHits = lw.TotalHits
+	report.Total2xx = lw.Total2xx
+	report.Total3xx = lw.Total3xx
+	report.Total4xx = lw.Total4xx
+	report.Total5xx = lw.Total5xx
+	report.TotalLate = lw.TotalLate
+	report.TotalErrors = lw.TotalErrors
+
+	return report, nil
+}
+
+// sortLogFiles orders files by the time of their first record, so that the
+// rotated files listed newest first by a glob like access.log* are read
+// oldest first. Standard input and the files without a dated record are read
+// last.
+func sortLogFiles(files []string, cfg *Config) []string {
+	firsts := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		firsts[file] = firstRecordTime(file, cfg)
+	}
+	sorted := append([]string(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		first, second := firsts[sorted[i]], firsts[sorted[j]]
+		if first.IsZero() || second.IsZero() {
+			return !first.IsZero()
+		}
+		return first.Before(second)
+	})
+	return sorted
+}
+
+// firstRecordTime returns the time of the first dated record of file, or the
+// zero time when it has none or can not be read.
+func firstRecordTime(file string, cfg *Config) time.Time {
+	var first time.Time
+	if file == StdinLogFile {
+		return first
+	}
+	parser, err := NewParser(cfg)
+	if err != nil {
+		return first
+	}
+	openLogReader(file, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if statitem, err := parser.Parse(scanner.Text()); err == nil && !statitem.Time.IsZero() {
+				first = statitem.Time
+				return nil
+			}
+		}
+		return scanner.Err()
+	})
+	return first
+}
+
+// readLogFile calls fn for every line of file, which may be gzip or zstd
+// compressed, or of standard input.
+func readLogFile(file string, fn func(line string)) error {
+	return openLogReader(file, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			fn(scanner.Text())
+		}
+		return scanner.Err()
+	})
+}
+
+// WriteText writes the report for humans.
+func (r *Report) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Files : %v\n", r.Files)
+	fmt.Fprintf(bw, "From  : %s\nTo    : %s\n\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	fmt.Fprintf(bw, "Total Hits   : %d\nTotal 2XX    : %d\nTotal 3XX    : %d\nTotal 4XX    : %d\nTotal 5XX    : %d\n",
+		r.TotalHits, r.Total2xx, r.Total3xx, r.Total4xx, r.Total5xx)
+	fmt.Fprintf(bw, "Total Late   : %d\nTotal Errors : %d\n\n", r.TotalLate, r.TotalErrors)
+
+	fmt.Fprintf(bw, "Top Sections :\n")
+	for _, kv := range sortCounts(r.TopSections) {
+		fmt.Fprintf(bw, "\t%s : %d\n", kv.key, kv.value)
+	}
+	fmt.Fprintf(bw, "\nTop Status :\n")
+	for _, kv := range sortCounts(r.TopStatus) {
+		fmt.Fprintf(bw, "\t%s : %d\n", kv.key, kv.value)
+	}
+
+	fmt.Fprintf(bw, "\nStats Average :\n")
+	for _, i := range r.Intervals {
+		fmt.Fprintf(bw, "\t%s  hits %d  2xx %d  3xx %d  4xx %d  5xx %d\n",
+			i.End.Format(time.RFC3339), i.AvgHits, i.Avg2xx, i.Avg3xx, i.Avg4xx, i.Avg5xx)
+	}
+
+	fmt.Fprintf(bw, "\nAlerting :\n")
+	if len(r.Alerts) == 0 {
+		fmt.Fprintf(bw, "\tNo alert\n")
+	}
+	for _, a := range r.Alerts {
+		fmt.Fprintf(bw, "\t%s\n", a.Message)
+	}
+	return bw.Flush()
+}
+
+// WriteJSON writes the report as a JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the report as CSV records of record type, time, key and value.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	itoa := strconv.Itoa
+
+	cw.Write([]string{"record", "time", "key", "value"})
+	for _, total := range []struct {
+		key   string
+		value int
+	}{
+		{"hits", r.TotalHits}, {"2xx", r.Total2xx}, {"3xx", r.Total3xx}, {"4xx", r.Total4xx},
+		{"5xx", r.Total5xx}, {"late", r.TotalLate}, {"errors", r.TotalErrors},
+	} {
+		cw.Write([]string{"total", "", total.key, itoa(total.value)})
+	}
+	for _, kv := range sortCounts(r.TopSections) {
+		cw.Write([]string{"section", "", kv.key, itoa(kv.value)})
+	}
+	for _, kv := range sortCounts(r.TopStatus) {
+		cw.Write([]string{"status", "", kv.key, itoa(kv.value)})
+	}
+	for _, i := range r.Intervals {
+		end := i.End.Format(time.RFC3339)
+		cw.Write([]string{"average", end, "hits", itoa(i.AvgHits)})
+		cw.Write([]string{"average", end, "2xx", itoa(i.Avg2xx)})
+		cw.Write([]string{"average", end, "3xx", itoa(i.Avg3xx)})
+		cw.Write([]string{"average", end, "4xx", itoa(i.Avg4xx)})
+		cw.Write([]string{"average", end, "5xx", itoa(i.Avg5xx)})
+	}
+	for _, a := range r.Alerts {
+		state := "recover"
+		if a.Alert {
+			state = "alert"
+		}
+		if a.Rule != "" {
+			cw.Write([]string{state, a.Time.Format(time.RFC3339), "rule", a.Rule})
+			continue
+		}
+		cw.Write([]string{state, a.Time.Format(time.RFC3339), "avg_hits", itoa(a.AvgHits)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write writes the report in format, one of text, json or csv.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return r.WriteJSON(w)
+	case "csv":
+		return r.WriteCSV(w)
+	case "text":
+		return r.WriteText(w)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+type count struct {
+	key   string
+	value int
+}
+
+// sortCounts returns the entries of m by decreasing count, then by key.
+func sortCounts(m map[string]int) []count {
+	counts := make([]count, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, count{k, v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].value != counts[j].value {
+			return counts[i].value > counts[j].value
+		}
+		return counts[i].key < counts[j].key
+	})
+	return counts
+}
@@ -0,0 +1,177 @@
+package logwatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReportSuite struct {
+	dir string
+}
+
+var _ = Suite(&ReportSuite{})
+
+var reportStart = time.Date(2016, 5, 11, 22, 0, 0, 0, time.FixedZone("", 2*3600))
+
+func (s *ReportSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// writeReportLog writes hits lines per second to file from start for the
+// given duration, gzip compressed when file ends with .gz.
+func writeReportLog(file string, start time.Time, seconds, hits int) error {
+	buf := &bytes.Buffer{}
+	for i := 0; i < seconds; i++ {
+		date := start.Add(time.Duration(i) * time.Second).Format(CommonLogDate)
+		for j := 0; j < hits; j++ {
+			fmt.Fprintf(buf, "127.0.0.1 - - [%s] \"GET /pages/%d HTTP/1.1\" %d 0\n", date, j, 200+100*(j%4))
+		}
+	}
+	fmt.Fprintf(buf, "not a log line\n")
+
+	data := buf.Bytes()
+	if strings.HasSuffix(file, ".gz") {
+		gzBuf := &bytes.Buffer{}
+		gz := gzip.NewWriter(gzBuf)
+		gz.Write(data)
+		gz.Close()
+		data = gzBuf.Bytes()
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func (s *ReportSuite) newWatcher() *Watcher {
+	return &Watcher{
+		StartTime: time.Now(),
+		Config: &Config{
+			RefreshInterval: 10,
+			AlertInterval:   20,
+			AlertThreshold:  15,
+		},
+		AlertMsg: make([]string, 0),
+	}
+}
+
+func (s *ReportSuite) TestReportOk(c *C) {
+	rotated := filepath.Join(s.dir, "access.log.1.gz")
+	current := filepath.Join(s.dir, "access.log")
+	c.Assert(writeReportLog(rotated, reportStart, 40, 2), IsNil)
+	c.Assert(writeReportLog(current, reportStart.Add(40*time.Second), 40, 1), IsNil)
+
+	// The files are read oldest first, whatever the order they are given in.
+	lw := s.newWatcher()
+	report, err := lw.Report([]string{current, rotated})
+	c.Assert(err, IsNil)
+	c.Assert(report.Files, DeepEquals, []string{rotated, current})
+	c.Assert(report.TotalLate, Equals, 0)
+
+	c.Assert(report.TotalHits, Equals, 120)
+	c.Assert(report.Total2xx, Equals, 80)
+	c.Assert(report.Total3xx, Equals, 40)
+	c.Assert(report.TotalErrors, Equals, 2)
+	c.Assert(report.TopSections, DeepEquals, map[string]int{"/pages": 120})
+	c.Assert(report.TopStatus, DeepEquals, map[string]int{"200": 80, "300": 40})
+	c.Assert(report.Start.Equal(reportStart), Equals, true)
+	c.Assert(report.End.Equal(reportStart.Add(79*time.Second)), Equals, true)
+
+	// 20 hits per refresh for 40s, then 10 hits per refresh.
+	c.Assert(report.Intervals, HasLen, 4)
+	c.Assert(report.Intervals[0].AvgHits, Equals, 20)
+	c.Assert(report.Intervals[1].AvgHits, Equals, 20)
+	c.Assert(report.Intervals[2].AvgHits, Equals, 10)
+	c.Assert(report.Intervals[3].AvgHits, Equals, 10)
+
+	c.Assert(report.Alerts, HasLen, 2)
+	c.Assert(report.Alerts[0].Alert, Equals, true)
+	c.Assert(report.Alerts[0].Time.Equal(reportStart.Add(16*time.Second)), Equals, true)
+	c.Assert(report.Alerts[0].AvgHits, Equals, 16)
+	c.Assert(report.Alerts[1].Alert, Equals, false)
+	c.Assert(report.Alerts[1].Time.Equal(reportStart.Add(49*time.Second)), Equals, true)
+	c.Assert(report.Alerts[1].Message, Matches, "Low traffic generated a recover - average hits = 15, triggered at May 11 22:00:49.000")
+}
+
+func (s *ReportSuite) TestReportSpikeOk(c *C) {
+	file := filepath.Join(s.dir, "spike.log")
+	buf := &bytes.Buffer{}
+	for i, hits := range []int{1, 3, 3, 3, 3, 3, 3, 1, 1, 1} {
+		for j := 0; j < 10*hits; j++ {
+			date := reportStart.Add(time.Duration(10*i+j/hits) * time.Second).Format(CommonLogDate)
+			fmt.Fprintf(buf, "127.0.0.1 - - [%s] \"GET /pages/%d HTTP/1.1\" 200 0\n", date, j)
+		}
+	}
+	c.Assert(os.WriteFile(file, buf.Bytes(), 0644), IsNil)
+
+	// The spike lasts three alert windows, and is reported once.
+	report, err := s.newWatcher().Report([]string{file})
+	c.Assert(err, IsNil)
+	c.Assert(report.Alerts, HasLen, 2)
+	c.Assert(report.Alerts[0].Alert, Equals, true)
+	c.Assert(report.Alerts[1].Alert, Equals, false)
+}
+
+func (s *ReportSuite) TestReportRulesOk(c *C) {
+	file := filepath.Join(s.dir, "access.log")
+	c.Assert(writeReportLog(file, reportStart, 40, 4), IsNil)
+	config := `{"rules": [{"name": "errors", "metric": "5xx", "window": 10, "comparison": ">", "threshold": 5, "severity": "critical"}]}`
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 20, AlertThreshold: 30, AlertFor: 5,
+		AlertConfig: writeAlertConfig(c, config)})
+	c.Assert(err, IsNil)
+
+	// One hit in 4 is a 5xx, so the rule fires once 6 of them are in its
+	// window, and the high traffic alert 5s after it went over 30.
+	report, err := lw.Report([]string{file})
+	c.Assert(err, IsNil)
+	c.Assert(report.Alerts, HasLen, 4)
+	c.Assert(report.Alerts[0].Rule, Equals, "errors")
+	c.Assert(report.Alerts[0].Alert, Equals, true)
+	c.Assert(report.Alerts[0].Time.Equal(reportStart.Add(6*time.Second)), Equals, true)
+	c.Assert(report.Alerts[1].Rule, Equals, "")
+	c.Assert(report.Alerts[1].Time.Equal(reportStart.Add(21*time.Second)), Equals, true)
+	c.Assert(report.Alerts[1].AvgHits, Equals, 40)
+	c.Assert(report.Alerts[2].Alert, Equals, false)
+	c.Assert(report.Alerts[3].Rule, Equals, "errors")
+	c.Assert(report.Alerts[3].Message, Matches, `Rule errors \[critical\] recovered - sum 5xx = 5, .*`)
+
+	out := &bytes.Buffer{}
+	c.Assert(report.Write(out, "csv"), IsNil)
+	c.Assert(out.String(), Matches, "(?s).*\nalert,2016-05-11T22:00:06\\+02:00,rule,errors\n.*")
+}
+
+func (s *ReportSuite) TestReportFileNoExistKo(c *C) {
+	_, err := s.newWatcher().Report([]string{filepath.Join(s.dir, "nope.log")})
+	c.Assert(err, ErrorMatches, "open .*nope.log: no such file or directory")
+}
+
+func (s *ReportSuite) TestReportWrite(c *C) {
+	file := filepath.Join(s.dir, "access.log")
+	c.Assert(writeReportLog(file, reportStart, 40, 2), IsNil)
+
+	report, err := s.newWatcher().Report([]string{file})
+	c.Assert(err, IsNil)
+
+	out := &bytes.Buffer{}
+	c.Assert(report.Write(out, "text"), IsNil)
+	c.Assert(out.String(), Matches, "(?s).*Total Hits   : 80\n.*/pages : 80\n.*High traffic generated an alert.*")
+
+	out.Reset()
+	c.Assert(report.Write(out, "json"), IsNil)
+	decoded := Report{}
+	c.Assert(json.Unmarshal(out.Bytes(), &decoded), IsNil)
+	c.Assert(decoded.TotalHits, Equals, 80)
+	c.Assert(decoded.Alerts, HasLen, len(report.Alerts))
+
+	out.Reset()
+	c.Assert(report.Write(out, "csv"), IsNil)
+	c.Assert(strings.HasPrefix(out.String(), "record,time,key,value\ntotal,,hits,80\n"), Equals, true)
+	c.Assert(out.String(), Matches, "(?s).*\nalert,2016-05-11T22:00:16\\+02:00,avg_hits,16\n.*")
+
+	c.Assert(report.Write(out, "xml"), ErrorMatches, `unknown report format "xml"`)
+}
@@ -0,0 +1,370 @@
+package logwatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// AlertConfig is the --alert-config file, which holds the alert rules
+// evaluated besides the high traffic alert.
+type AlertConfig struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a named alert rule. It aggregates a metric of the lines matching
+// its filters over a sliding window, and fires while the result compares to
+// its threshold. Rules with an Expr fire while their condition holds over
+// their window instead.
+type Rule struct {
+	Name string `json:"name"`
+	// Expr is a condition written in the language of Expr, which replaces
+	// the metric, filters, aggregation, comparison and threshold.
+	Expr string `json:"expr,omitempty"`
+	// Metric is hits, bytes, 2xx, 3xx, 4xx or 5xx.
+	Metric string `json:"metric"`
+	// Section and Source, when set, filter the lines of the rule.
+	Section string `json:"section,omitempty"`
+	Source  string `json:"source,omitempty"`
+	// Aggregation is sum, rate for the sum per second, avg for the average
+	// per line, or percent for the percentage of the lines. It defaults to
+	// sum.
+	Aggregation string `json:"aggregation,omitempty"`
+	// Window is the length of the sliding window in seconds, the alert
+	// interval by default.
+	Window     int     `json:"window,omitempty"`
+	Comparison string  `json:"comparison"`
+	Threshold  float64 `json:"threshold"`
+	// Severity is info, warning or critical. It defaults to warning.
+	Severity string `json:"severity,omitempty"`
+	// For is how long, in seconds, the rule must keep firing before its
+	// alert is raised.
+	For int `json:"for,omitempty"`
+	// RecoverThreshold, when set, is the threshold the value must no longer
+	// compare to for the rule to recover, instead of the threshold. It sits
+	// on the other side of the threshold than the values firing the rule.
+	RecoverThreshold *float64 `json:"recover_threshold,omitempty"`
+	// RecoverExpr, when set, is the condition recovering a rule with an
+	// Expr, instead of its condition no longer holding.
+	RecoverExpr string `json:"recover_expr,omitempty"`
+	// FlapWindow and FlapThreshold override --flap-window and
+	// --flap-threshold: a rule changing state FlapThreshold times within
+	// FlapWindow seconds is flapping, and its changes are then reported as
+	// one event.
+	FlapWindow    int `json:"flap_window,omitempty"`
+	FlapThreshold int `json:"flap_threshold,omitempty"`
+
+	expr        *Expr
+	recoverExpr *Expr
+}
+
+var (
+	ruleMetrics      = []string{"hits", "bytes", "2xx", "3xx", "4xx", "5xx"}
+	ruleAggregations = []string{"sum", "rate", "avg", "percent"}
+	ruleComparisons  = []string{">", ">=", "<", "<=", "==", "!="}
+	ruleSeverities   = []string{"info", "warning", "critical"}
+
+	maxRuleHistory = 100
+)
+
+// LoadAlertConfig reads and validates an --alert-config file.
+func LoadAlertConfig(file string) (*AlertConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ac := &AlertConfig{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(ac); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := ac.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return ac, nil
+}
+
+// Validate checks the rules, and sets the defaults of the fields left out.
+func (ac *AlertConfig) Validate() error {
+	names := make(map[string]bool)
+	for i := range ac.Rules {
+		rule := &ac.Rules[i]
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// Validate checks the rule, and sets the defaults of the fields left out.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without a name")
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if !oneOf(r.Severity, ruleSeverities) {
+		return fmt.Errorf("rule %q: invalid severity %q, expected info, warning or critical", r.Name, r.Severity)
+	}
+	if r.Window < 0 {
+		return fmt.Errorf("rule %q: invalid window %d", r.Name, r.Window)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("rule %q: invalid for %d", r.Name, r.For)
+	}
+	if r.FlapWindow < 0 || r.FlapThreshold < 0 || r.FlapThreshold == 1 {
+		return fmt.Errorf("rule %q: invalid flap window %d or threshold %d", r.Name, r.FlapWindow, r.FlapThreshold)
+	}
+
+	if r.Expr != "" {
+		if r.Metric != "" || r.Section != "" || r.Source != "" || r.Aggregation != "" || r.Comparison != "" ||
+			r.Threshold != 0 || r.RecoverThreshold != nil {
+			return fmt.Errorf("rule %q: expr replaces metric, section, source, aggregation, comparison and thresholds", r.Name)
+		}
+		expr, err := ParseExpr(r.Expr)
+		if err != nil {
+			return fmt.Errorf("rule %q: expr %w", r.Name, err)
+		}
+		r.expr = expr
+		if r.RecoverExpr != "" {
+			if r.recoverExpr, err = ParseExpr(r.RecoverExpr); err != nil {
+				return fmt.Errorf("rule %q: recover_expr %w", r.Name, err)
+			}
+		}
+		return nil
+	}
+	if r.RecoverExpr != "" {
+		return fmt.Errorf("rule %q: recover_expr needs an expr", r.Name)
+	}
+
+	if r.Aggregation == "" {
+		r.Aggregation = "sum"
+	}
+	if !oneOf(r.Metric, ruleMetrics) {
+		return fmt.Errorf("rule %q: invalid metric %q, expected hits, bytes, 2xx, 3xx, 4xx or 5xx", r.Name, r.Metric)
+	}
+	if !oneOf(r.Aggregation, ruleAggregations) {
+		return fmt.Errorf("rule %q: invalid aggregation %q, expected sum, rate, avg or percent", r.Name, r.Aggregation)
+	}
+	if !oneOf(r.Comparison, ruleComparisons) {
+		return fmt.Errorf("rule %q: invalid comparison %q, expected >, >=, <, <=, == or !=", r.Name, r.Comparison)
+	}
+	if r.RecoverThreshold != nil {
+		threshold := *r.RecoverThreshold
+		switch {
+		case r.Comparison == "==" || r.Comparison == "!=":
+			return fmt.Errorf("rule %q: recover_threshold needs a comparison of >, >=, < or <=", r.Name)
+		case r.Comparison[0] == '>' && threshold > r.Threshold, r.Comparison[0] == '<' && threshold < r.Threshold:
+			return fmt.Errorf("rule %q: recover_threshold %s is on the firing side of threshold %s",
+				r.Name, formatValue(threshold), formatValue(r.Threshold))
+		}
+	}
+	return nil
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matches tells whether event passes the filters of the rule.
+func (r *Rule) matches(event *CommonLog) bool {
+	return (r.Section == "" || section(event.Request) == r.Section) &&
+		(r.Source == "" || event.Source == r.Source)
+}
+
+// metric returns the value of the metric of the rule for event.
+func (r *Rule) metric(event *CommonLog) float64 {
+	switch r.Metric {
+	case "hits":
+		return 1
+	case "bytes":
+		return float64(event.Bytes)
+	}
+	// The status class metrics, 2xx to 5xx.
+	if event.Status/100 == int(r.Metric[0]-'0') {
+		return 1
+	}
+	return 0
+}
+
+// aggregate returns the value of the rule for the counts of its window.
+func (r *Rule) aggregate(counts ruleCounts) float64 {
+	switch r.Aggregation {
+	case "rate":
+		return counts.value / float64(r.Window)
+	case "avg", "percent":
+		if counts.hits == 0 {
+			return 0
+		}
+		if r.Aggregation == "percent" {
+			return 100 * counts.value / float64(counts.hits)
+		}
+		return counts.value / float64(counts.hits)
+	}
+	return counts.value
+}
+
+// compare tells whether value fires the rule.
+func (r *Rule) compare(value float64) bool {
+	return r.compareTo(value, r.Threshold)
+}
+
+// recovers tells whether value recovers the rule, which happens once it no
+// longer compares to the recover threshold.
+func (r *Rule) recovers(value float64) bool {
+	if r.RecoverThreshold == nil {
+		return !r.compare(value)
+	}
+	return !r.compareTo(value, *r.RecoverThreshold)
+}
+
+func (r *Rule) compareTo(value, threshold float64) bool {
+	switch r.Comparison {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// describe returns what the rule measures, like "percent 5xx of /api".
+func (r *Rule) describe() string {
+	desc := r.Aggregation + " " + r.Metric
+	if r.Section != "" {
+		desc += " of " + r.Section
+	}
+	if r.Source != "" {
+		desc += " from " + r.Source
+	}
+	return desc
+}
+
+// RuleState is a rule, whether it fires, its last value and the history of
+// its alerts. The value of the rules with a condition is 1 while it holds,
+// and 0 otherwise. A rule is pending while it would fire but has not for
+// the For of the rule yet, and flapping while its changes are reported as one
+// event.
+type RuleState struct {
+	Rule
+	Firing   bool
+	Pending  bool
+	Flapping bool
+	Value    float64
+	Since    time.Time
+	History  []string
+
+	tracker alertTracker
+}
+
+// newRuleState returns the state of rule, flapping after the flap window and
+// threshold of cfg unless the rule sets its own.
+func newRuleState(rule Rule, cfg *Config) *RuleState {
+	st := &RuleState{Rule: rule}
+	st.tracker.pending = time.Duration(rule.For) * time.Second
+	st.tracker.flapWindow = time.Duration(cfg.FlapWindow) * time.Second
+	st.tracker.flapChanges = cfg.FlapThreshold
+	if rule.FlapWindow > 0 {
+		st.tracker.flapWindow = time.Duration(rule.FlapWindow) * time.Second
+	}
+	if rule.FlapThreshold > 0 {
+		st.tracker.flapChanges = rule.FlapThreshold
+	}
+	return st
+}
+
+// evaluate updates the state of the rule at now with the counts of its window,
+// or the item of its window for the rules with a condition, and returns the
+// message of the alert raised or recovered, or of the rule starting or
+// stopping to flap, if any, with its event.
+func (st *RuleState) evaluate(counts ruleCounts, item *StatItem, now time.Time) (string, alertEvent) {
+	var breach, recovers bool
+	if st.expr != nil {
+		window := time.Duration(st.Window) * time.Second
+		breach = st.expr.Eval(item, window)
+		recovers = !breach
+		if st.recoverExpr != nil {
+			recovers = st.recoverExpr.Eval(item, window)
+		}
+		st.Value = 0
+		if breach {
+			st.Value = 1
+		}
+	} else {
+		st.Value = st.aggregate(counts)
+		breach = st.compare(st.Value)
+		recovers = st.recovers(st.Value)
+	}
+
+	firing, event := st.tracker.step(st.Firing, breach, recovers, now)
+	if firing != st.Firing {
+		st.Firing = firing
+		st.Since = now
+	}
+	st.Pending = !firing && st.tracker.isPending()
+	st.Flapping = st.tracker.flapping
+
+	var msg string
+	switch event {
+	case eventNone:
+		return "", event
+	case eventFire:
+		msg = fmt.Sprintf("Rule %s [%s] generated an alert - %s", st.Name, st.Severity, st.condition())
+	case eventRecover:
+		msg = fmt.Sprintf("Rule %s [%s] recovered - %s", st.Name, st.Severity, st.condition())
+	case eventFlapStart:
+		msg = fmt.Sprintf("Rule %s [%s] is flapping - %d changes in %s, alerts suppressed, %s",
+			st.Name, st.Severity, len(st.tracker.changes), st.tracker.flapWindow, st.condition())
+	case eventFlapEnd:
+		msg = fmt.Sprintf("Rule %s [%s] stopped flapping - %s", st.Name, st.Severity, st.condition())
+	}
+	msg += ", triggered at " + now.Format(time.StampMilli)
+	st.History = append(st.History, msg)
+	if len(st.History) > maxRuleHistory {
+		st.History = st.History[len(st.History)-maxRuleHistory:]
+	}
+	return msg, event
+}
+
+// condition returns the condition of the rule, as it holds or not.
+func (st *RuleState) condition() string {
+	switch {
+	case st.expr != nil && st.Value == 1:
+		return st.Expr
+	case st.expr != nil && st.RecoverExpr != "" && !st.Firing:
+		return st.RecoverExpr
+	case st.expr != nil:
+		return "not (" + st.Expr + ")"
+	case st.Firing && st.compare(st.Value):
+		return fmt.Sprintf("%s = %s %s %s", st.describe(), formatValue(st.Value), st.Comparison, formatValue(st.Threshold))
+	}
+	return fmt.Sprintf("%s = %s", st.describe(), formatValue(st.Value))
+}
+
+// formatValue formats value with up to two decimals.
+func formatValue(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
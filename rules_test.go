@@ -0,0 +1,190 @@
+package logwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RulesSuite struct{}
+
+var _ = Suite(&RulesSuite{})
+
+const testAlertConfig = `{"rules": [
+	{"name": "errors", "metric": "5xx", "aggregation": "percent", "comparison": ">", "threshold": 2, "severity": "critical"},
+	{"name": "api", "metric": "hits", "section": "/api", "window": 60, "comparison": ">", "threshold": 5},
+	{"name": "bandwidth", "metric": "bytes", "aggregation": "rate", "window": 10, "comparison": ">=", "threshold": 100, "severity": "info"}
+]}`
+
+func writeAlertConfig(c *C, config string) string {
+	file := filepath.Join(c.MkDir(), "alerts.json")
+	c.Assert(os.WriteFile(file, []byte(config), 0644), IsNil)
+	return file
+}
+
+func (s *RulesSuite) TestLoadAlertConfigOk(c *C) {
+	ac, err := LoadAlertConfig(writeAlertConfig(c, testAlertConfig))
+	c.Assert(err, IsNil)
+	c.Assert(ac.Rules, HasLen, 3)
+	c.Assert(ac.Rules[1], DeepEquals, Rule{Name: "api", Metric: "hits", Section: "/api", Aggregation: "sum",
+		Window: 60, Comparison: ">", Threshold: 5, Severity: "warning"})
+}
+
+func (s *RulesSuite) TestLoadAlertConfigKo(c *C) {
+	for _, t := range []struct{ config, expected string }{
+		{`{"rules": [{"name": "a", "metric": "hit", "comparison": ">"}]}`,
+			`.*: rule "a": invalid metric "hit", expected .*`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": "=>"}]}`,
+			`.*: rule "a": invalid comparison "=>", expected .*`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "aggregation": "max"}]}`,
+			`.*: rule "a": invalid aggregation "max", expected .*`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "severity": "fatal"}]}`,
+			`.*: rule "a": invalid severity "fatal", expected .*`},
+		{`{"rules": [{"metric": "hits", "comparison": ">"}]}`,
+			`.*: rule without a name`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "treshold": 1}]}`,
+			`.*: json: unknown field "treshold"`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">"}, {"name": "a", "metric": "hits", "comparison": ">"}]}`,
+			`.*: rule "a" is defined twice`},
+		{`{"rules": [{"name": "a", "expr": "hits > 1", "metric": "hits"}]}`,
+			`.*: rule "a": expr replaces metric, .*`},
+		{`{"rules": [{"name": "a", "expr": "hits > 1 and hitz > 1"}]}`,
+			`.*: rule "a": expr column 14: unknown metric "hitz"`},
+		{`{"rules": [{"name": "a", "expr": "hits > 1", "recover_expr": "hits <"}]}`,
+			`.*: rule "a": recover_expr column 7: unexpected end of expression`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "recover_expr": "hits < 1"}]}`,
+			`.*: rule "a": recover_expr needs an expr`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "threshold": 10, "recover_threshold": 12}]}`,
+			`.*: rule "a": recover_threshold 12 is on the firing side of threshold 10`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": "==", "recover_threshold": 1}]}`,
+			`.*: rule "a": recover_threshold needs a comparison of >, >=, < or <=`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "for": -1}]}`,
+			`.*: rule "a": invalid for -1`},
+		{`{"rules": [{"name": "a", "metric": "hits", "comparison": ">", "flap_threshold": 1}]}`,
+			`.*: rule "a": invalid flap window 0 or threshold 1`},
+	} {
+		_, err := LoadAlertConfig(writeAlertConfig(c, t.config))
+		c.Assert(err, ErrorMatches, t.expected)
+	}
+	_, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 20, AlertConfig: "nope.json"})
+	c.Assert(err, ErrorMatches, "open nope.json: no such file or directory")
+}
+
+func (s *RulesSuite) TestRuleAggregate(c *C) {
+	counts := ruleCounts{value: 30, hits: 600}
+	rule := Rule{Aggregation: "sum", Window: 60, Comparison: ">", Threshold: 29}
+	c.Assert(rule.aggregate(counts), Equals, 30.0)
+	c.Assert(rule.compare(rule.aggregate(counts)), Equals, true)
+	rule.Aggregation = "rate"
+	c.Assert(rule.aggregate(counts), Equals, 0.5)
+	rule.Aggregation = "avg"
+	c.Assert(rule.aggregate(counts), Equals, 0.05)
+	rule.Aggregation = "percent"
+	c.Assert(rule.aggregate(counts), Equals, 5.0)
+	c.Assert(rule.aggregate(ruleCounts{}), Equals, 0.0)
+	rule.Comparison = "<="
+	c.Assert(rule.compare(29), Equals, true)
+	c.Assert(rule.compare(29.5), Equals, false)
+}
+
+func (s *RulesSuite) TestRuleStateHysteresis(c *C) {
+	recoverThreshold := 80.0
+	rule := Rule{Name: "busy", Metric: "hits", Aggregation: "sum", Comparison: ">", Threshold: 100,
+		RecoverThreshold: &recoverThreshold, For: 2, Severity: "warning", FlapWindow: 60, FlapThreshold: 4}
+	c.Assert(rule.Validate(), IsNil)
+	st := newRuleState(rule, &Config{})
+
+	messages := make([]string, 0)
+	for i, value := range []float64{120, 120, 90, 120, 120, 120, 100, 90, 80, 120, 120, 120, 80, 120, 120, 120, 80} {
+		msg, _ := st.evaluate(ruleCounts{value: value}, nil, windowStart.Add(time.Duration(i)*time.Second))
+		if msg != "" {
+			messages = append(messages, msg)
+		}
+		if i == 1 {
+			c.Assert(st.Pending, Equals, true)
+		}
+	}
+
+	// The rule fires once 120 lasts 2s, stays firing down to 80, and the
+	// fourth change makes it flap.
+	c.Assert(messages, HasLen, 4)
+	c.Assert(messages[0], Matches, `Rule busy \[warning\] generated an alert - sum hits = 120 > 100, triggered at May 11 22:00:05.000`)
+	c.Assert(messages[1], Matches, `Rule busy \[warning\] recovered - sum hits = 80, .*`)
+	c.Assert(messages[2], Matches, `Rule busy \[warning\] generated an alert - .* 22:00:11.000`)
+	c.Assert(messages[3], Matches, `Rule busy \[warning\] is flapping - 4 changes in 1m0s, alerts suppressed, sum hits = 80, .*`)
+	c.Assert(st.Flapping, Equals, true)
+	c.Assert(st.Firing, Equals, false)
+	c.Assert(st.History, DeepEquals, messages)
+}
+
+func (s *RulesSuite) TestStatsAggregatorRules(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 120, AlertThreshold: 1000,
+		AlertConfig: writeAlertConfig(c, testAlertConfig)})
+	c.Assert(err, IsNil)
+	c.Assert(lw.Rules[0].Window, Equals, 120)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+
+	// 3 errors out of 100 hits, 6 of them on /api, 50 bytes each.
+	for i := 0; i < 100; i++ {
+		item := CommonLog{Request: "/pages", Status: 200, Bytes: 50, Time: windowStart.Add(time.Duration(i) * 100 * time.Millisecond)}
+		if i < 3 {
+			item.Status = 503
+		}
+		if i >= 94 {
+			item.Request = "/api/users"
+		}
+		shard.Add(item)
+	}
+
+	alerts, snap := agg.Alert(windowStart.Add(10 * time.Second))
+	c.Assert(alerts, HasLen, 3)
+	c.Assert(alerts[0].Rule, Equals, "errors")
+	c.Assert(alerts[0].Severity, Equals, "critical")
+	c.Assert(alerts[0].Value, Equals, 3.0)
+	c.Assert(alerts[0].Message, Matches, `Rule errors \[critical\] generated an alert - percent 5xx = 3 > 2, triggered at May 11 22:00:10.000`)
+	c.Assert(alerts[1].Message, Matches, `Rule api \[warning\] generated an alert - sum hits of /api = 6 > 5, .*`)
+	c.Assert(alerts[2].Message, Matches, `Rule bandwidth \[info\] generated an alert - rate bytes = 500 >= 100, .*`)
+	c.Assert(snap.Rules, HasLen, 3)
+	c.Assert(snap.Rules[0].Firing, Equals, true)
+	c.Assert(snap.Rules[0].History, HasLen, 1)
+
+	// The lines leave the 10s window of the bandwidth rule first.
+	alerts, _ = agg.Alert(windowStart.Add(11 * time.Second))
+	c.Assert(alerts, HasLen, 0)
+	alerts, snap = agg.Alert(windowStart.Add(20 * time.Second))
+	c.Assert(alerts, HasLen, 1)
+	c.Assert(alerts[0].Recovered, Equals, true)
+	c.Assert(alerts[0].Message, Matches, `Rule bandwidth \[info\] recovered - rate bytes = 0, .*`)
+	c.Assert(snap.Rules[2].Firing, Equals, false)
+	c.Assert(snap.Rules[2].History, HasLen, 2)
+	c.Assert(snap.Rules[0].Firing, Equals, true)
+}
+
+func (s *RulesSuite) TestStatsAggregatorExprRule(c *C) {
+	config := `{"rules": [{"name": "errors", "expr": "rate(status_5xx) / rate(hits) > 0.05 and hits > 10", "window": 10}]}`
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 120, AlertThreshold: 1000,
+		AlertConfig: writeAlertConfig(c, config)})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+	for i := 0; i < 20; i++ {
+		item := CommonLog{Request: "/pages", Status: 200, Time: windowStart.Add(time.Duration(i) * 100 * time.Millisecond)}
+		if i < 2 {
+			item.Status = 500
+		}
+		shard.Add(item)
+	}
+
+	alerts, snap := agg.Alert(windowStart.Add(5 * time.Second))
+	c.Assert(alerts, HasLen, 1)
+	c.Assert(alerts[0].Message, Matches, `Rule errors \[warning\] generated an alert - rate\(status_5xx\) / rate\(hits\) > 0.05 and hits > 10, .*`)
+	c.Assert(snap.Rules[0].Value, Equals, 1.0)
+
+	alerts, _ = agg.Alert(windowStart.Add(15 * time.Second))
+	c.Assert(alerts, HasLen, 1)
+	c.Assert(alerts[0].Recovered, Equals, true)
+	c.Assert(alerts[0].Message, Matches, `Rule errors \[warning\] recovered - not \(rate\(status_5xx\) .*\), .*`)
+}
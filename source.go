@@ -0,0 +1,243 @@
+package logwatcher
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadLetter appends the lines rejected by the parsers to a file shared by
+// every log source.
+type deadLetter struct {
+	sync.Mutex
+	file *os.File
+}
+
+func (dl *deadLetter) WriteLine(line string) {
+	if dl == nil {
+		return
+	}
+	dl.Lock()
+	defer dl.Unlock()
+	dl.file.WriteString(line + "\n")
+}
+
+// StdinLogFile is the --log-file value reading the log from standard input.
+const StdinLogFile = "-"
+
+// isGlob tells whether a --log-file value is a glob pattern.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// ExpandLogFiles returns the files matching the --log-file values, in order.
+// Plain paths are kept as they are, even when they do not exist yet.
+func ExpandLogFiles(patterns []string) ([]string, error) {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if isGlob(pattern) {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, err
+			}
+			sort.Strings(matches)
+		}
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// NewSources returns the sources of the logs given by cfg: the files and
+// globs of --log-file, standard input and the --syslog-listen addresses.
+// Files saved in checkpoint resume where the last run stopped.
+func NewSources(cfg *Config, checkpoint *State) []Source {
+	sources := make([]Source, 0)
+	patterns := make([]string, 0)
+	stdin := false
+	for _, pattern := range cfg.LogFile {
+		if pattern == StdinLogFile {
+			stdin = true
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	if len(patterns) > 0 {
+		sources = append(sources, &FileSource{
+			Patterns:     patterns,
+			Follow:       !cfg.Replay,
+			GlobInterval: time.Duration(cfg.GlobInterval) * time.Second,
+			Checkpoint:   checkpoint,
+			Directives:   statefulFormat(cfg),
+		})
+	}
+	if stdin {
+		sources = append(sources, &StdinSource{})
+	}
+	for _, listen := range cfg.SyslogListen {
+		sources = append(sources, &SyslogSource{Listen: listen})
+	}
+	return sources
+}
+
+// FileSource tails log files and globs, following them across rotations.
+// Files which are not followed are read once from their start, one after
+// another. Files matching a glob which show up later are read from their
+// start. Directives, when set, sends the directive lines before the saved
+// position again when resuming a file, for the parsers keeping state like the
+// W3C one.
+type FileSource struct {
+	Patterns     []string
+	Follow       bool
+	GlobInterval time.Duration
+	Checkpoint   *State
+	Directives   bool
+
+	files   []string
+	streams []<-chan Line
+}
+
+// Open opens the files matching the patterns.
+func (fs *FileSource) Open(ctx context.Context) error {
+	files, err := ExpandLogFiles(fs.Patterns)
+	if err != nil {
+		return err
+	}
+	fs.files = files
+
+	for _, file := range files {
+		lines, err := fs.tail(ctx, file, !fs.Follow)
+		if err != nil {
+			return err
+		}
+		fs.streams = append(fs.streams, lines)
+	}
+	return nil
+}
+
+// tail follows file, or reads it once when not following.
+func (fs *FileSource) tail(ctx context.Context, file string, fromStart bool) (<-chan Line, error) {
+	var fl *follower
+	var err error
+	if pos, ok := fs.Checkpoint.Position(file); ok {
+		fl, err = resumeFollower(file, pos, fs.Follow, fs.Directives)
+	} else {
+		fl, err = openFollower(file, fromStart, fs.Follow)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go fl.run(ctx)
+	return fl.lines, nil
+}
+
+// Run sends the lines of the opened files, and looks for new files matching
+// the globs when following.
+func (fs *FileSource) Run(ctx context.Context, out chan<- Line) {
+	if !fs.Follow {
+		for _, lines := range fs.streams {
+			if !forward(ctx, lines, out) {
+				return
+			}
+		}
+		return
+	}
+
+	var readers sync.WaitGroup
+	defer readers.Wait()
+	read := func(lines <-chan Line) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			forward(ctx, lines, out)
+		}()
+	}
+	for _, lines := range fs.streams {
+		read(lines)
+	}
+
+	globs := make([]string, 0)
+	for _, pattern := range fs.Patterns {
+		if isGlob(pattern) {
+			globs = append(globs, pattern)
+		}
+	}
+	if len(globs) == 0 || fs.GlobInterval <= 0 {
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, file := range fs.files {
+		known[file] = true
+	}
+	ticker := time.NewTicker(fs.GlobInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		matches, err := ExpandLogFiles(globs)
+		if err != nil {
+			continue
+		}
+		for _, file := range matches {
+			if known[file] {
+				continue
+			}
+			lines, err := fs.tail(ctx, file, true)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			known[file] = true
+			read(lines)
+		}
+	}
+}
+
+// forward sends the lines of in on out until in is closed, and tells whether
+// ctx is not done.
+func forward(ctx context.Context, in <-chan Line, out chan<- Line) bool {
+	for line := range in {
+		if !send(ctx, out, line) {
+			return false
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// StdinSource reads the log written to standard input until it is closed.
+type StdinSource struct{}
+
+func (StdinSource) Open(ctx context.Context) error {
+	return nil
+}
+
+func (StdinSource) Run(ctx context.Context, out chan<- Line) {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if !send(ctx, out, Line{Source: "stdin", Text: scanner.Text()}) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+	}
+}
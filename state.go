@@ -0,0 +1,103 @@
+package logwatcher
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is the checkpoint saved to the --state-file, from which a restart
+// resumes reading the log files and the statistics where they were left.
+type State struct {
+	SavedAt    time.Time               `json:"saved_at"`
+	Files      map[string]FilePosition `json:"files"`
+	Totals     StatsTotal              `json:"totals"`
+	Sources    map[string]*StatsTotal  `json:"sources,omitempty"`
+	Averages   StatsAvg                `json:"averages"`
+	AlertState bool                    `json:"alert_state"`
+	AlertMsg   []string                `json:"alert_msg"`
+	Watermark  time.Time               `json:"watermark"`
+	// Pending holds the lines read but not yet counted in a refresh window,
+	// so that the file positions and the totals agree.
+	Pending []*CommonLog `json:"pending,omitempty"`
+}
+
+// Position returns the saved position of file. It is safe on a nil State.
+func (st *State) Position(file string) (FilePosition, bool) {
+	if st == nil {
+		return FilePosition{}, false
+	}
+	pos, ok := st.Files[file]
+	return pos, ok
+}
+
+// LoadState reads a checkpoint written by SaveState.
+func LoadState(file string) (*State, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return state, nil
+}
+
+// RestoreState puts back the statistics and alert state of a checkpoint, and
+// keeps it for the sources and the aggregator to resume from.
+func (lw *Watcher) RestoreState(state *State) {
+	*lw.StatsTotal = state.Totals
+	*lw.StatsAvg = state.Averages
+	lw.Sources = state.Sources
+	lw.AlertState = state.AlertState
+	lw.AlertMsg = append(lw.AlertMsg[:0], state.AlertMsg...)
+	lw.Watermark = state.Watermark
+	lw.Checkpoint = state
+}
+
+// SaveState writes the checkpoint of the read positions of the files, the
+// pending lines and the statistics to file. The file is replaced atomically
+// so that a crash never leaves a truncated checkpoint.
+func (lw *Watcher) SaveState(file string, files map[string]FilePosition, pending []*CommonLog) error {
+	state := State{
+		SavedAt:    time.Now(),
+		Files:      files,
+		Totals:     *lw.StatsTotal,
+		Sources:    lw.Sources,
+		Averages:   *lw.StatsAvg,
+		AlertState: lw.AlertState,
+		AlertMsg:   lw.AlertMsg,
+		Watermark:  lw.Watermark,
+		Pending:    pending,
+	}
+	data, err := json.Marshal(&state)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Println(err)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		log.Println(err)
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package logwatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type StateSuite struct {
+	dir string
+}
+
+var _ = Suite(&StateSuite{})
+
+func (s *StateSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *StateSuite) TestSaveStateOk(c *C) {
+	file := filepath.Join(s.dir, "state.json")
+	lw := Watcher{
+		StatsTotal: &StatsTotal{TotalHits: 42, Total2xx: 40, Total5xx: 2},
+		StatsAvg:   &StatsAvg{AvgHits: 7},
+		Sources:    map[string]*StatsTotal{"access.log": {TotalHits: 42}},
+		AlertState: true,
+		AlertMsg:   []string{"High traffic generated an alert"},
+		Watermark:  time.Date(2016, 5, 11, 22, 0, 0, 0, time.UTC),
+	}
+	files := map[string]FilePosition{"access.log": {Inode: 12, Offset: 4096}}
+	pending := []*CommonLog{{Request: "/a", Status: 200, Source: "access.log"}}
+	c.Assert(lw.SaveState(file, files, pending), IsNil)
+
+	state, err := LoadState(file)
+	c.Assert(err, IsNil)
+	c.Assert(state.Files, DeepEquals, files)
+	c.Assert(state.Pending, HasLen, 1)
+	c.Assert(state.Pending[0].Request, Equals, "/a")
+
+	restored := Watcher{StatsTotal: &StatsTotal{}, StatsAvg: &StatsAvg{}, AlertMsg: make([]string, 0)}
+	restored.RestoreState(state)
+	c.Assert(*restored.StatsTotal, DeepEquals, *lw.StatsTotal)
+	c.Assert(restored.AvgHits, Equals, 7)
+	c.Assert(restored.Sources["access.log"].TotalHits, Equals, 42)
+	c.Assert(restored.AlertState, Equals, true)
+	c.Assert(restored.AlertMsg, DeepEquals, lw.AlertMsg)
+	c.Assert(restored.Watermark.Equal(lw.Watermark), Equals, true)
+
+	pos, ok := restored.Checkpoint.Position("access.log")
+	c.Assert(ok, Equals, true)
+	c.Assert(pos.Offset, Equals, int64(4096))
+	_, ok = (*State)(nil).Position("access.log")
+	c.Assert(ok, Equals, false)
+
+	// The checkpoint is replaced without leaving temporary files behind.
+	c.Assert(lw.SaveState(file, files, nil), IsNil)
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*"))
+	c.Assert(matches, DeepEquals, []string{file})
+}
+
+func (s *StateSuite) TestLoadStateKo(c *C) {
+	_, err := LoadState(filepath.Join(s.dir, "nope.json"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	file := filepath.Join(s.dir, "state.json")
+	c.Assert(os.WriteFile(file, []byte("{"), 0644), IsNil)
+	_, err = LoadState(file)
+	c.Assert(err, ErrorMatches, "unexpected end of JSON input")
+}
+
+// readPosition reads n follower lines and returns where the last one ends.
+func readPosition(c *C, fl *follower, prefix string, n int) FilePosition {
+	var pos FilePosition
+	for i := 0; i < n; i++ {
+		select {
+		case line := <-fl.lines:
+			pos = line.Position
+		case <-time.After(3 * time.Second):
+			c.Fatalf("missing line %s%d", prefix, i)
+		}
+	}
+	return pos
+}
+
+func (s *StateSuite) TestResumeFollowerOk(c *C) {
+	path := filepath.Join(s.dir, "access.log")
+	w, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer w.Close()
+	writeLines(w, "a", 5)
+
+	fl, err := openFollower(path, true, false)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	pos := readPosition(c, fl, "a", 5)
+	c.Assert(pos.Offset, Equals, int64(15))
+
+	// Lines written while stopped are read on resume, the others are not.
+	writeLines(w, "b", 5)
+	fl, err = resumeFollower(path, pos, false, false)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	expectLines(c, fl, "b", 5)
+}
+
+func (s *StateSuite) TestResumeFollowerRotatedOk(c *C) {
+	path := filepath.Join(s.dir, "access.log")
+	w, err := os.Create(path)
+	c.Assert(err, IsNil)
+	writeLines(w, "a", 5)
+	w.Close()
+
+	fl, err := openFollower(path, true, false)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	pos := readPosition(c, fl, "a", 5)
+
+	// While stopped, more lines are written, then the file is rotated and
+	// compressed, and the new file is written.
+	w, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	writeLines(w, "b", 5)
+	w.Close()
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write(data)
+	gz.Close()
+	c.Assert(os.Rename(path, path+".1"), IsNil)
+	c.Assert(os.WriteFile(path+".1.gz", buf.Bytes(), 0644), IsNil)
+	w, err = os.Create(path)
+	c.Assert(err, IsNil)
+	writeLines(w, "c", 5)
+	w.Close()
+	c.Assert(os.Remove(path+".1"), IsNil)
+
+	fl, err = resumeFollower(path, pos, false, false)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	expectLines(c, fl, "b", 5)
+	expectLines(c, fl, "c", 5)
+}
+
+func (s *StateSuite) TestResumeFollowerDirectivesOk(c *C) {
+	path := filepath.Join(s.dir, "u_ex160511.log")
+	header := "#Software: Microsoft Internet Information Services 10.0\n#Fields: date time cs-uri-stem sc-status\n"
+	c.Assert(os.WriteFile(path, []byte(header+"2016-05-11 22:00:00 /a 200\n"), 0644), IsNil)
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	pos := FilePosition{Inode: inode(info), Offset: int64(len(header)) + 27}
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	fmt.Fprint(w, "2016-05-11 22:00:01 /b 200\n")
+	w.Close()
+
+	// The directives are sent again, without a position, before the lines
+	// written while stopped.
+	fl, err := resumeFollower(path, pos, false, true)
+	c.Assert(err, IsNil)
+	go fl.run(context.Background())
+	texts := make([]string, 0)
+	for line := range fl.lines {
+		texts = append(texts, line.Text)
+		if strings.HasPrefix(line.Text, "#") {
+			c.Assert(line.Position, Equals, FilePosition{})
+		}
+	}
+	c.Assert(texts, DeepEquals, []string{
+		"#Software: Microsoft Internet Information Services 10.0",
+		"#Fields: date time cs-uri-stem sc-status",
+		"2016-05-11 22:00:01 /b 200",
+	})
+}
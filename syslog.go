@@ -0,0 +1,359 @@
+package logwatcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadSyslog is returned for messages without a valid syslog envelope.
+var ErrBadSyslog = errors.New("invalid syslog message")
+
+// maxSyslogMessage bounds the size of the messages read from the listeners.
+const maxSyslogMessage = 64 * 1024
+
+// SyslogMessage is a syslog message stripped of its envelope.
+type SyslogMessage struct {
+	Priority int
+	Time     time.Time
+	Hostname string
+	AppName  string
+	Message  string
+}
+
+// Source returns the source tag of the message, made of its hostname and
+// app-name.
+func (m *SyslogMessage) Source() string {
+	switch {
+	case m.Hostname == "":
+		return m.AppName
+	case m.AppName == "":
+		return m.Hostname
+	}
+	return m.Hostname + "/" + m.AppName
+}
+
+// ParseSyslog parses RFC 5424 messages, and RFC 3164 messages such as the
+// ones nginx sends with access_log syslog:server=...
+func ParseSyslog(line string) (*SyslogMessage, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "<") {
+		return nil, ErrBadSyslog
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, ErrBadSyslog
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority > 191 {
+		return nil, fmt.Errorf("%w priority %q", ErrBadSyslog, line[1:end])
+	}
+
+	msg := &SyslogMessage{Priority: priority}
+	rest := line[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		err = parseRFC5424(msg, rest[2:])
+	} else {
+		err = parseRFC3164(msg, rest, time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseRFC5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG.
+func parseRFC5424(msg *SyslogMessage, rest string) error {
+	fields := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		field, next, ok := strings.Cut(rest, " ")
+		if !ok && i < 4 {
+			return ErrBadSyslog
+		}
+		fields = append(fields, field)
+		rest = next
+	}
+
+	if fields[0] != "-" {
+		date, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("%w timestamp %q", ErrBadSyslog, fields[0])
+		}
+		msg.Time = date
+	}
+	if fields[1] != "-" {
+		msg.Hostname = fields[1]
+	}
+	if fields[2] != "-" {
+		msg.AppName = fields[2]
+	}
+
+	rest, err := skipStructuredData(rest)
+	if err != nil {
+		return err
+	}
+	msg.Message = strings.TrimPrefix(rest, "\ufeff")
+	return nil
+}
+
+// skipStructuredData returns what follows the structured data of a message.
+func skipStructuredData(rest string) (string, error) {
+	if strings.HasPrefix(rest, "-") {
+		return strings.TrimPrefix(rest[1:], " "), nil
+	}
+	for strings.HasPrefix(rest, "[") {
+		end := sdElementEnd(rest)
+		if end < 0 {
+			return "", fmt.Errorf("%w structured data", ErrBadSyslog)
+		}
+		rest = rest[end+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", fmt.Errorf("%w structured data", ErrBadSyslog)
+	}
+	return strings.TrimPrefix(rest, " "), nil
+}
+
+// sdElementEnd returns the index of the bracket closing the structured data
+// element s starts with, skipping quoted and escaped characters, or -1.
+func sdElementEnd(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == ']' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRFC3164 parses Mmm dd hh:mm:ss HOSTNAME TAG: MSG. The timestamp has no
+// year, so it is taken in the year which puts it closest before now.
+func parseRFC3164(msg *SyslogMessage, rest string, now time.Time) error {
+	const layout = time.Stamp
+	if len(rest) < len(layout)+1 || rest[len(layout)] != ' ' {
+		return fmt.Errorf("%w timestamp", ErrBadSyslog)
+	}
+	date, err := time.ParseInLocation(layout, rest[:len(layout)], now.Location())
+	if err != nil {
+		return fmt.Errorf("%w timestamp %q", ErrBadSyslog, rest[:len(layout)])
+	}
+	date = date.AddDate(now.Year(), 0, 0)
+	if date.After(now.Add(24 * time.Hour)) {
+		date = date.AddDate(-1, 0, 0)
+	}
+	msg.Time = date
+	rest = rest[len(layout)+1:]
+
+	hostname, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return fmt.Errorf("%w hostname", ErrBadSyslog)
+	}
+	msg.Hostname = hostname
+
+	// The tag ends with a colon, after an optional [pid].
+	tag, body, ok := strings.Cut(rest, ": ")
+	if !ok || strings.ContainsRune(tag, ' ') {
+		msg.Message = rest
+		return nil
+	}
+	if i := strings.IndexByte(tag, '['); i >= 0 {
+		tag = tag[:i]
+	}
+	msg.AppName = tag
+	msg.Message = body
+	return nil
+}
+
+// SyslogSource receives access logs as syslog messages, and tags their lines
+// with the hostname and app-name of their envelope.
+type SyslogSource struct {
+	Listen string
+	// Addr is the address listened on, once opened.
+	Addr net.Addr
+
+	lines <-chan Line
+}
+
+// Open starts listening.
+func (ss *SyslogSource) Open(ctx context.Context) error {
+	lines, addr, err := listenSyslog(ctx, ss.Listen)
+	if err != nil {
+		return err
+	}
+	log.Println("Listening for syslog messages on", addr.Network(), addr)
+	ss.lines, ss.Addr = lines, addr
+	return nil
+}
+
+// Run sends the lines of the messages received until ctx is done.
+func (ss *SyslogSource) Run(ctx context.Context, out chan<- Line) {
+	for {
+		select {
+		case line := <-ss.lines:
+			if !send(ctx, out, decodeSyslog(line)) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decodeSyslog strips the syslog envelope of line, which then takes the
+// source and the date of the envelope, if any.
+func decodeSyslog(line Line) Line {
+	msg, err := ParseSyslog(line.Text)
+	if err != nil {
+		line.Err = err
+		return line
+	}
+	if msg.Source() != "" {
+		line.Source = msg.Source()
+	}
+	line.Text, line.Time = msg.Message, msg.Time
+	return line
+}
+
+// listenSyslog listens for syslog messages on listen, given as udp://address,
+// tcp://address, or a bare address for UDP, and streams the raw messages until
+// ctx is done. It returns the address actually listened on.
+func listenSyslog(ctx context.Context, listen string) (<-chan Line, net.Addr, error) {
+	network, address, ok := strings.Cut(listen, "://")
+	if !ok {
+		network, address = "udp", listen
+	}
+	lines := make(chan Line)
+
+	switch network {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		context.AfterFunc(ctx, func() { conn.Close() })
+		go readSyslogPackets(ctx, conn, lines)
+		return lines, conn.LocalAddr(), nil
+	case "tcp", "tcp4", "tcp6":
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		context.AfterFunc(ctx, func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Println(err)
+					}
+					return
+				}
+				go readSyslogConn(ctx, conn, lines)
+			}
+		}()
+		return lines, ln.Addr(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported syslog network %q in %q", network, listen)
+}
+
+// readSyslogPackets reads one message per datagram.
+func readSyslogPackets(ctx context.Context, conn net.PacketConn, lines chan<- Line) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println(err)
+			}
+			return
+		}
+		if !send(ctx, lines, Line{Source: "syslog", Text: strings.TrimRight(string(buf[:n]), "\r\n")}) {
+			return
+		}
+	}
+}
+
+// maxFrameDigits is the number of digits of the longest frame size.
+var maxFrameDigits = len(strconv.Itoa(maxSyslogMessage))
+
+// readFrameSize reads the size of an octet counted frame, digits followed by
+// a space, reading no more digits than a valid size has.
+func readFrameSize(r *bufio.Reader) (int, error) {
+	size := make([]byte, 0, maxFrameDigits+1)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b == ' ' {
+			break
+		}
+		size = append(size, b)
+		if b < '0' || b > '9' || len(size) > maxFrameDigits {
+			return 0, fmt.Errorf("%w frame size %q", ErrBadSyslog, size)
+		}
+	}
+	n, err := strconv.Atoi(string(size))
+	if err != nil || n <= 0 || n > maxSyslogMessage {
+		return 0, fmt.Errorf("%w frame size %q", ErrBadSyslog, size)
+	}
+	return n, nil
+}
+
+// readSyslogConn reads the messages of a TCP connection, framed either by
+// octet counting or by newlines (RFC 6587).
+func readSyslogConn(ctx context.Context, conn net.Conn, lines chan<- Line) {
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	r := bufio.NewReaderSize(conn, maxSyslogMessage)
+
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return
+		}
+
+		var line string
+		if first[0] >= '0' && first[0] <= '9' {
+			n, err := readFrameSize(r)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				log.Println(err)
+				return
+			}
+			line = string(buf)
+		} else {
+			// Lines longer than the buffer end with bufio.ErrBufferFull.
+			buf, err := r.ReadSlice('\n')
+			line = string(buf)
+			if err != nil && (err != io.EOF || line == "") {
+				if err != io.EOF {
+					log.Println(err)
+				}
+				return
+			}
+		}
+		if !send(ctx, lines, Line{Source: "syslog", Text: strings.TrimRight(line, "\r\n")}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package logwatcher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SyslogSuite struct{}
+
+var _ = Suite(&SyslogSuite{})
+
+const syslogAccessLine = `10.0.0.1 - - [11/May/2016:22:02:21 +0200] "GET /api/users HTTP/1.1" 200 512`
+
+func (s *SyslogSuite) TestParseSyslogRFC3164Ok(c *C) {
+	msg, err := ParseSyslog("<190>May 11 22:02:21 web1 nginx: " + syslogAccessLine)
+	c.Assert(err, IsNil)
+	c.Assert(msg.Priority, Equals, 190)
+	c.Assert(msg.Hostname, Equals, "web1")
+	c.Assert(msg.AppName, Equals, "nginx")
+	c.Assert(msg.Source(), Equals, "web1/nginx")
+	c.Assert(msg.Message, Equals, syslogAccessLine)
+	c.Assert(msg.Time.Month(), Equals, time.May)
+	c.Assert(msg.Time.Day(), Equals, 11)
+
+	msg, err = ParseSyslog("<13>Oct  1 08:00:00 web2 httpd[1234]: hello\n")
+	c.Assert(err, IsNil)
+	c.Assert(msg.Source(), Equals, "web2/httpd")
+	c.Assert(msg.Message, Equals, "hello")
+}
+
+func (s *SyslogSuite) TestParseRFC3164Year(c *C) {
+	now := time.Date(2017, 1, 1, 0, 10, 0, 0, time.UTC)
+	msg := &SyslogMessage{}
+	c.Assert(parseRFC3164(msg, "Dec 31 23:59:00 web1 nginx: hello", now), IsNil)
+	c.Assert(msg.Time.Equal(time.Date(2016, 12, 31, 23, 59, 0, 0, time.UTC)), Equals, true)
+}
+
+func (s *SyslogSuite) TestParseSyslogRFC5424Ok(c *C) {
+	msg, err := ParseSyslog(`<165>1 2016-05-11T22:02:21.123+02:00 web1 nginx 42 access ` +
+		`[meta@1 a="x \"]\" y"][other@1 b="c"] ` + syslogAccessLine)
+	c.Assert(err, IsNil)
+	c.Assert(msg.Source(), Equals, "web1/nginx")
+	c.Assert(msg.Message, Equals, syslogAccessLine)
+	c.Assert(msg.Time.Equal(time.Date(2016, 5, 11, 20, 2, 21, 123e6, time.UTC)), Equals, true)
+
+	msg, err = ParseSyslog("<165>1 - - - - - -")
+	c.Assert(err, IsNil)
+	c.Assert(msg.Source(), Equals, "")
+	c.Assert(msg.Time.IsZero(), Equals, true)
+	c.Assert(msg.Message, Equals, "")
+}
+
+func (s *SyslogSuite) TestParseSyslogKo(c *C) {
+	for line, reason := range map[string]string{
+		syslogAccessLine:                   "invalid syslog message",
+		"<999>1 - - - - - - hi":            `invalid syslog message priority "999"`,
+		"<13>1 yesterday web1 app - - - x": `invalid syslog message timestamp "yesterday"`,
+		"<13>1 - web1 app - - [meta x":     "invalid syslog message structured data",
+		"<13>1 - web1":                     "invalid syslog message",
+		"<13>Someday web1 nginx: hello":    "invalid syslog message timestamp",
+	} {
+		_, err := ParseSyslog(line)
+		c.Check(err, ErrorMatches, reason, Commentf(line))
+	}
+}
+
+func (s *SyslogSuite) TestListenSyslogUDPOk(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines, addr, err := listenSyslog(ctx, "udp://127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	conn, err := net.Dial("udp", addr.String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	fmt.Fprintf(conn, "<190>May 11 22:02:21 web1 nginx: %s\n", syslogAccessLine)
+
+	select {
+	case line := <-lines:
+		c.Assert(line.Text, Equals, "<190>May 11 22:02:21 web1 nginx: "+syslogAccessLine)
+	case <-time.After(2 * time.Second):
+		c.Fatal("no syslog message received")
+	}
+}
+
+func (s *SyslogSuite) TestListenSyslogTCPOk(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines, addr, err := listenSyslog(ctx, "tcp://127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	conn, err := net.Dial("tcp", addr.String())
+	c.Assert(err, IsNil)
+	// Octet counted frames may hold newlines, the others end with one.
+	fmt.Fprintf(conn, "%d %s", len("<13>1 - web1 app - - - a\nb"), "<13>1 - web1 app - - - a\nb")
+	fmt.Fprintf(conn, "<13>1 - web2 app - - - c\n")
+	conn.Close()
+
+	for _, expected := range []string{"<13>1 - web1 app - - - a\nb", "<13>1 - web2 app - - - c"} {
+		select {
+		case line := <-lines:
+			c.Assert(line.Text, Equals, expected)
+		case <-time.After(2 * time.Second):
+			c.Fatal("no syslog message received")
+		}
+	}
+
+	_, _, err = listenSyslog(ctx, "unix:///tmp/syslog.sock")
+	c.Assert(err, ErrorMatches, `unsupported syslog network "unix" .*`)
+}
+
+func (s *SyslogSuite) TestReadFrameSize(c *C) {
+	n, err := readFrameSize(bufio.NewReader(strings.NewReader("65536 <13>")))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 65536)
+	for input, expected := range map[string]string{
+		"65537 <13>":     `invalid syslog message frame size "65537"`,
+		"0 <13>":         `invalid syslog message frame size "0"`,
+		"12a <13>":       `invalid syslog message frame size "12a"`,
+		"1234567890123 ": `invalid syslog message frame size "123456"`,
+		"123":            `EOF`,
+	} {
+		_, err := readFrameSize(bufio.NewReader(strings.NewReader(input)))
+		c.Assert(err, ErrorMatches, expected, Commentf("input %q", input))
+	}
+
+	// A peer sending digits without a space is cut off.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines, addr, err := listenSyslog(ctx, "tcp://127.0.0.1:0")
+	c.Assert(err, IsNil)
+	conn, err := net.Dial("tcp", addr.String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(conn, strings.Repeat("1", 1024))
+	_, err = conn.Read(make([]byte, 1))
+	c.Assert(err, ErrorMatches, "EOF|.*connection reset by peer")
+	c.Assert(lines, HasLen, 0)
+}
+
+func (s *SyslogSuite) TestDecodeSyslog(c *C) {
+	line := decodeSyslog(Line{Source: "syslog", Text: "<190>May 11 22:02:21 web1 nginx: " + syslogAccessLine})
+	c.Assert(line.Err, IsNil)
+	c.Assert(line.Source, Equals, "web1/nginx")
+	c.Assert(line.Text, Equals, syslogAccessLine)
+	c.Assert(line.Time.Day(), Equals, 11)
+
+	line = decodeSyslog(Line{Source: "syslog", Text: "<165>1 - - - - - - " + syslogAccessLine})
+	c.Assert(line.Source, Equals, "syslog")
+	c.Assert(line.Time.IsZero(), Equals, true)
+
+	line = decodeSyslog(Line{Source: "syslog", Text: "no envelope"})
+	c.Assert(line.Err, ErrorMatches, "invalid syslog message")
+	c.Assert(line.Text, Equals, "no envelope")
+}
+
+func (s *SyslogSuite) TestSyslogSourceOk(c *C) {
+	cfg := config
+	cfg.SyslogListen = []string{"udp://127.0.0.1:0"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, agg, err := openTestPipeline(ctx, &cfg, nil)
+	c.Assert(err, IsNil)
+	go p.read(ctx)
+
+	conn, err := net.Dial("udp", p.Sources[0].(*SyslogSource).Addr.String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	fmt.Fprintf(conn, "<190>May 11 22:02:21 web1 nginx: %s\n", syslogAccessLine)
+	fmt.Fprintf(conn, "<165>1 2016-05-11T22:02:22Z web2 nginx - - - %s\n", syslogAccessLine)
+	fmt.Fprintf(conn, "no envelope\n")
+
+	sources := make([]string, 0)
+	for len(sources) < 2 {
+		select {
+		case item := <-agg.events:
+			sources = append(sources, item.Source)
+			c.Assert(item.Request, Equals, "/api/users")
+		case line := <-p.lines.c:
+			c.Assert(line, Equals, syslogAccessLine)
+		case <-time.After(2 * time.Second):
+			c.Fatal("no log line received")
+		}
+	}
+	c.Assert(sources, DeepEquals, []string{"web1/nginx", "web2/nginx"})
+
+	for {
+		select {
+		case <-p.lines.c:
+			continue
+		case perr := <-agg.errs:
+			c.Assert(perr.Reason, Equals, "invalid syslog message")
+		case <-time.After(2 * time.Second):
+			c.Fatal("no parse error received")
+		}
+		break
+	}
+}
+
+func (s *SyslogSuite) TestCheckAlertSource(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 1, AlertInterval: 1, AlertThreshold: 1})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+	now := time.Date(2016, 5, 11, 22, 2, 21, 0, time.UTC)
+	shard.Add(CommonLog{Request: "/a", Status: 200, Source: "web1/nginx", Time: now})
+	shard.Add(CommonLog{Request: "/b", Status: 200, Source: "web2/nginx", Time: now})
+	shard.Add(CommonLog{Request: "/b", Status: 200, Source: "web2/nginx", Time: now})
+
+	alerts, _ := agg.Alert(now.Add(time.Second))
+	c.Assert(alerts, HasLen, 1)
+	c.Assert(alerts[0].Message, Matches, "High traffic generated an alert .*, busiest source web2/nginx")
+	c.Assert(lw.SourceHits, DeepEquals, map[string]int{"web1/nginx": 1, "web2/nginx": 2})
+}
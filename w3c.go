@@ -0,0 +1,196 @@
+package logwatcher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoFields is returned for records found before any #Fields directive.
+	ErrNoFields = errors.New("no #Fields directive before log record")
+	// ErrFieldCount is returned for records not matching the #Fields directive.
+	ErrFieldCount = errors.New("field count mismatch")
+)
+
+// W3CParser parses the W3C Extended Log File Format written by IIS and some
+// CDNs. Columns are declared by the #Fields directive, which may change in
+// the middle of a file, so the parser keeps the last directives it has seen.
+type W3CParser struct {
+	fields   []string
+	date     string
+	software string
+}
+
+func init() {
+	RegisterParser("w3c", func(cfg *Config) (Parser, error) {
+		return NewW3CParser(), nil
+	})
+}
+
+// NewW3CParser returns a parser for the W3C Extended Log File Format.
+func NewW3CParser() *W3CParser {
+	return &W3CParser{}
+}
+
+// Fields returns the columns declared by the last #Fields directive.
+func (p *W3CParser) Fields() []string {
+	return p.fields
+}
+
+// Date returns the value of the last #Date directive.
+func (p *W3CParser) Date() string {
+	return p.date
+}
+
+// Software returns the value of the last #Software directive.
+func (p *W3CParser) Software() string {
+	return p.software
+}
+
+// Stateful implements the StatefulParser interface.
+func (p *W3CParser) Stateful() bool {
+	return true
+}
+
+// Parse implements the Parser interface.
+func (p *W3CParser) Parse(line string) (*CommonLog, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "#") {
+		p.directive(line[1:])
+		return nil, ErrSkipLine
+	}
+	if strings.TrimSpace(line) == "" {
+		return nil, ErrSkipLine
+	}
+	if p.fields == nil {
+		return nil, ErrNoFields
+	}
+
+	values := splitW3C(line)
+	if len(values) != len(p.fields) {
+		return nil, fmt.Errorf("%w: got %d fields, #Fields directive declares %d",
+			ErrFieldCount, len(values), len(p.fields))
+	}
+
+	cl := &CommonLog{}
+	date, clock, query := "", "", ""
+	for i, name := range p.fields {
+		value := values[i]
+
+		switch name {
+		case "date":
+			date = value
+		case "time":
+			clock = value
+		case "c-ip":
+			cl.IP = value
+		case "cs-username":
+			cl.User = value
+		case "cs-method":
+			cl.Method = value
+		case "cs-uri-stem":
+			cl.Request = value
+		case "cs-uri":
+			if cl.Request == "" {
+				cl.Request = value
+			}
+		case "cs-uri-query":
+			query = value
+		case "cs-version":
+			cl.Proto = value
+		case "sc-status":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q", ErrBadStatus, value)
+			}
+			cl.Status = status
+		case "sc-bytes":
+			if value != "-" {
+				bytes, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w %q", ErrBadBytes, value)
+				}
+				cl.Bytes = bytes
+			}
+		case "cs(Referer)":
+			cl.Referrer = value
+		case "cs(User-Agent)":
+			// IIS writes spaces in the user agent as '+'.
+			cl.UserAgent = strings.Replace(value, "+", " ", -1)
+		default:
+			if cl.Extra == nil {
+				cl.Extra = make(map[string]string)
+			}
+			cl.Extra[name] = value
+		}
+	}
+
+	if query != "" && query != "-" {
+		cl.Request += "?" + query
+	}
+	// Records without a date column are dated by the #Date directive.
+	if date == "" && p.date != "" {
+		date = strings.Fields(p.date)[0]
+	}
+	cl.Date = strings.TrimSpace(date + " " + clock)
+	// W3C dates and times are written in UTC.
+	if date != "" && clock != "" {
+		t, err := parseDate(cl.Date, "2006-01-02 15:04:05", "2006-01-02 15:04:05.999999999")
+		if err != nil {
+			return nil, err
+		}
+		cl.Time = t
+	}
+
+	return cl, nil
+}
+
+func (p *W3CParser) directive(line string) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return
+	}
+	value := strings.TrimSpace(line[i+1:])
+
+	switch line[:i] {
+	case "Fields":
+		p.fields = strings.Fields(value)
+	case "Date":
+		p.date = value
+	case "Software":
+		p.software = value
+	}
+}
+
+// splitW3C splits a W3C record on whitespace, keeping double quoted values
+// in one piece.
+func splitW3C(line string) []string {
+	values := make([]string, 0)
+
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+		case '"':
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				values = append(values, line[i+1:])
+				return values
+			}
+			values = append(values, line[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				values = append(values, line[i:])
+				return values
+			}
+			values = append(values, line[i:i+end])
+			i += end
+		}
+	}
+	return values
+}
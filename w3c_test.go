@@ -0,0 +1,83 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type W3CSuite struct{}
+
+var _ = Suite(&W3CSuite{})
+
+func (s *W3CSuite) TestW3CParserOk(c *C) {
+	p := NewW3CParser()
+
+	for _, line := range []string{
+		"#Software: Microsoft Internet Information Services 10.0",
+		"#Version: 1.0",
+		"#Date: 2016-05-11 22:00:00",
+		"#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip " +
+			"cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status sc-bytes time-taken",
+	} {
+		cl, err := p.Parse(line)
+		c.Assert(cl, IsNil)
+		c.Assert(err, Equals, ErrSkipLine)
+	}
+	c.Assert(p.Software(), Equals, "Microsoft Internet Information Services 10.0")
+	c.Assert(p.Date(), Equals, "2016-05-11 22:00:00")
+	c.Assert(p.Fields(), HasLen, 16)
+
+	cl, err := p.Parse("2016-05-11 22:02:21 10.0.0.10 GET /pages/create id=3 443 - 10.0.0.1 " +
+		"Mozilla/5.0+(Windows+NT+10.0) https://my.site.com/ 200 0 0 5120 15")
+	c.Assert(err, IsNil)
+	c.Assert(cl.Date, Equals, "2016-05-11 22:02:21")
+	c.Assert(cl.Time, Equals, time.Date(2016, 5, 11, 22, 2, 21, 0, time.UTC))
+	c.Assert(cl.IP, Equals, "10.0.0.1")
+	c.Assert(cl.Method, Equals, "GET")
+	c.Assert(cl.Request, Equals, "/pages/create?id=3")
+	c.Assert(cl.User, Equals, "-")
+	c.Assert(cl.UserAgent, Equals, "Mozilla/5.0 (Windows NT 10.0)")
+	c.Assert(cl.Referrer, Equals, "https://my.site.com/")
+	c.Assert(cl.Status, Equals, 200)
+	c.Assert(cl.Bytes, Equals, int64(5120))
+	c.Assert(cl.Extra["time-taken"], Equals, "15")
+	c.Assert(cl.Extra["s-ip"], Equals, "10.0.0.10")
+}
+
+func (s *W3CSuite) TestW3CParserFieldsChangeOk(c *C) {
+	p := NewW3CParser()
+
+	_, err := p.Parse("#Fields: date time c-ip cs-uri-stem sc-status")
+	c.Assert(err, Equals, ErrSkipLine)
+	cl, err := p.Parse("2016-05-11 22:02:21 10.0.0.1 /pages 200")
+	c.Assert(err, IsNil)
+	c.Assert(cl.Request, Equals, "/pages")
+
+	_, err = p.Parse("#Date: 2016-05-12 00:00:00")
+	c.Assert(err, Equals, ErrSkipLine)
+	_, err = p.Parse("#Fields: time c-ip cs-method cs-uri sc-status sc-bytes cs(User-Agent)")
+	c.Assert(err, Equals, ErrSkipLine)
+	cl, err = p.Parse(`00:00:01 10.0.0.2 POST /api/users 201 - "curl/7.47.0 (x86_64)"`)
+	c.Assert(err, IsNil)
+	c.Assert(cl.Date, Equals, "2016-05-12 00:00:01")
+	c.Assert(cl.Time, Equals, time.Date(2016, 5, 12, 0, 0, 1, 0, time.UTC))
+	c.Assert(cl.Method, Equals, "POST")
+	c.Assert(cl.Request, Equals, "/api/users")
+	c.Assert(cl.Status, Equals, 201)
+	c.Assert(cl.Bytes, Equals, int64(0))
+	c.Assert(cl.UserAgent, Equals, "curl/7.47.0 (x86_64)")
+}
+
+func (s *W3CSuite) TestW3CParserKo(c *C) {
+	p := NewW3CParser()
+
+	_, err := p.Parse("2016-05-11 22:02:21 10.0.0.1 /pages 200")
+	c.Assert(err, Equals, ErrNoFields)
+
+	p.Parse("#Fields: date time c-ip cs-uri-stem sc-status")
+	_, err = p.Parse("2016-05-11 22:02:21 10.0.0.1 /pages")
+	c.Assert(err, ErrorMatches, "field count mismatch: got 4 fields, #Fields directive declares 5")
+	_, err = p.Parse("2016-05-11 22:02:21 10.0.0.1 /pages OK")
+	c.Assert(err, ErrorMatches, `invalid status "OK"`)
+}
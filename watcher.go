@@ -0,0 +1,823 @@
+// Package logwatcher computes statistics and raises alerts on HTTP access
+// logs. A Watcher built by New reads the configured logs once started, and
+// hands its snapshots and alerts to its sinks; cmd/logwatcher draws them in
+// the terminal.
+package logwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommonLog is a struct collecting fields for http logs in common format.
+type CommonLog struct {
+	IP         string
+	Identifier string
+	User       string
+	Date       string
+	Method     string
+	Request    string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Referrer   string
+	UserAgent  string
+	Extra      map[string]string
+	Time       time.Time
+	Source     string
+	Position   FilePosition
+}
+
+// StatItem is a struct collecting log information during execution.
+type StatItem struct {
+	Timestamp     time.Time
+	Hits          int
+	Bytes         int64
+	Status2xx     int
+	Status3xx     int
+	Status4xx     int
+	Status5xx     int
+	TopSections   map[string]int
+	TopStatus     map[string]int
+	TopReferrers  map[string]int
+	TopUserAgents map[string]int
+}
+
+// Merge adds the counts of other to the ones of item.
+func (item *StatItem) Merge(other *StatItem) {
+	item.Hits += other.Hits
+	item.Bytes += other.Bytes
+	item.Status2xx += other.Status2xx
+	item.Status3xx += other.Status3xx
+	item.Status4xx += other.Status4xx
+	item.Status5xx += other.Status5xx
+	for k, v := range other.TopSections {
+		item.TopSections[k] += v
+	}
+	for k, v := range other.TopStatus {
+		item.TopStatus[k] += v
+	}
+	for k, v := range other.TopReferrers {
+		item.TopReferrers[k] += v
+	}
+	for k, v := range other.TopUserAgents {
+		item.TopUserAgents[k] += v
+	}
+}
+
+// StatsTotal is a struct collecting the totals since start, and the top
+// counts of the last refresh window.
+type StatsTotal struct {
+	TotalHits     int
+	TotalLate     int
+	Total2xx      int
+	Total3xx      int
+	Total4xx      int
+	Total5xx      int
+	TopSections   map[string]int
+	TopStatus     map[string]int
+	TopReferrers  map[string]int
+	TopUserAgents map[string]int
+}
+
+type StatsAvg struct {
+	AvgHits int
+	Avg2xx  int
+	Avg3xx  int
+	Avg4xx  int
+	Avg5xx  int
+}
+
+// StatsErrors is a struct collecting the log lines the parser rejected.
+type StatsErrors struct {
+	TotalErrors  int
+	ErrorReasons map[string]int
+	ErrorSamples []string
+}
+
+// Snapshot is a copy of the statistics of a Watcher, safe to keep and read
+// from any goroutine.
+type Snapshot struct {
+	Time       time.Time
+	Elapsed    time.Duration
+	Total      StatsTotal
+	Avg        StatsAvg
+	Errors     StatsErrors
+	Sources    map[string]StatsTotal
+	AlertState bool
+	AlertMsg   []string
+	// AlertPending and AlertFlapping tell whether the high traffic alert is
+	// pending or flapping, like the RuleState of a rule.
+	AlertPending  bool
+	AlertFlapping bool
+	Rules         []RuleState
+	// Dropped is the number of lines dropped by the overflow policy.
+	Dropped uint64
+}
+
+// Alert is an alert raised, or recovered, by the high traffic alert or by an
+// alert rule, which is then named with its severity and value.
+type Alert struct {
+	Time      time.Time
+	Recovered bool
+	AvgHits   int
+	// Source is the busiest source of the high traffic alert, or the
+	// source the rule is filtered on.
+	Source   string
+	Rule     string
+	Severity string
+	Value    float64
+	// Flapping is set on the alerts reporting that an alert starts or stops
+	// flapping, rather than a change of its state.
+	Flapping bool
+	Message  string
+}
+
+// Watcher watches access logs, and reports their statistics and alerts to
+// its sinks. It runs a Pipeline reading the sources of its Config, and is the
+// Aggregator of the pipeline.
+type Watcher struct {
+	StartTime     time.Time
+	AlertMsg      []string
+	AlertState    bool
+	CollectionNum int
+	Watermark     time.Time
+	Clock         Clock
+	Sources       map[string]*StatsTotal
+	SourceHits    map[string]int
+	AlertSource   string
+	Rules         []*RuleState
+	Checkpoint    *State
+	*Config
+	*StatsTotal
+	*StatsAvg
+	*StatsErrors
+
+	alert    alertTracker
+	notify   *NotifySink
+	mu       sync.Mutex
+	pipeline *Pipeline
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+var maxErrorSamples = 10
+
+// New returns a Watcher of the logs given by cfg, resuming from the state file
+// of cfg when there is one.
+func New(cfg *Config) (*Watcher, error) {
+	if _, err := NewParser(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.RefreshInterval <= 0 || cfg.AlertInterval%cfg.RefreshInterval != 0 {
+		return nil, errors.New("the modulo of alertInterval / refreshInterval must be zero for average calculation to work")
+	}
+	if _, err := ParseOverflowPolicy(cfg.OverflowPolicy); err != nil {
+		return nil, err
+	}
+	if cfg.AlertFor < 0 || cfg.FlapWindow < 0 || cfg.FlapWindow > 0 && cfg.FlapThreshold < 2 {
+		return nil, errors.New("the alert for and flap window must not be negative, and the flap threshold must be at least 2")
+	}
+
+	var clock Clock = WallClock{}
+	if cfg.Replay {
+		speed, err := ParseReplaySpeed(cfg.ReplaySpeed)
+		if err != nil {
+			return nil, err
+		}
+		clock = NewReplayClock(speed)
+	}
+
+	lw := &Watcher{
+		StartTime:     time.Now(),
+		Clock:         clock,
+		Config:        cfg,
+		StatsTotal:    &StatsTotal{},
+		StatsAvg:      &StatsAvg{},
+		StatsErrors:   &StatsErrors{ErrorReasons: make(map[string]int)},
+		AlertMsg:      make([]string, 0),
+		CollectionNum: cfg.AlertInterval / cfg.RefreshInterval,
+		alert: alertTracker{
+			pending:     time.Duration(cfg.AlertFor) * time.Second,
+			flapWindow:  time.Duration(cfg.FlapWindow) * time.Second,
+			flapChanges: cfg.FlapThreshold,
+		},
+	}
+
+	if cfg.AlertConfig != "" {
+		ac, err := LoadAlertConfig(cfg.AlertConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range ac.Rules {
+			if rule.Window == 0 {
+				rule.Window = cfg.AlertInterval
+			}
+			lw.Rules = append(lw.Rules, newRuleState(rule, cfg))
+		}
+	}
+
+	// A checkpoint left by the last run resumes its files and statistics.
+	if cfg.StateFile != "" {
+		state, err := LoadState(cfg.StateFile)
+		switch {
+		case err == nil:
+			lw.RestoreState(state)
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	agg := newStatsAggregator(lw)
+	lw.pipeline = NewPipeline(cfg, clock)
+	lw.pipeline.Sources = NewSources(cfg, lw.Checkpoint)
+	lw.pipeline.Aggregator = agg
+	notifiers, err := NewNotifiers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Replays would notify the incidents of the past.
+	if len(notifiers) > 0 && cfg.Replay {
+		return nil, errors.New("alerts can not be notified when replaying")
+	}
+	if len(notifiers) > 0 {
+		lw.notify = NewNotifySink(notifiers)
+		lw.pipeline.Sinks = append(lw.pipeline.Sinks, lw.notify)
+	}
+	if cfg.StateFile != "" {
+		lw.pipeline.Checkpoint = agg.checkpoint
+		lw.pipeline.CheckpointInterval = time.Duration(cfg.StateInterval) * time.Second
+	}
+	return lw, nil
+}
+
+// AddSource adds a log source to the ones of the Config. It must be called
+// before Start.
+func (lw *Watcher) AddSource(src Source) {
+	lw.pipeline.Sources = append(lw.pipeline.Sources, src)
+}
+
+// AddSink adds an output of the statistics and alerts. It must be called
+// before Start.
+func (lw *Watcher) AddSink(sink Sink) {
+	lw.pipeline.Sinks = append(lw.pipeline.Sinks, sink)
+}
+
+// Start opens the logs and starts watching them until ctx is done or Stop is
+// called. It fails when a log can not be opened.
+func (lw *Watcher) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	if err := lw.pipeline.Open(ctx); err != nil {
+		cancel()
+		return err
+	}
+	lw.cancel = cancel
+
+	if lw.notify != nil {
+		go lw.notify.Run()
+	}
+	lw.wg.Add(1)
+	go func() {
+		defer lw.wg.Done()
+		lw.pipeline.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop stops watching the logs, saving the state file if there is one, and
+// waits for the Watcher to be done.
+func (lw *Watcher) Stop() {
+	if lw.cancel == nil {
+		return
+	}
+	lw.cancel()
+	if replay, ok := lw.Clock.(*ReplayClock); ok {
+		replay.Stop()
+	}
+	lw.wg.Wait()
+	if lw.notify != nil {
+		lw.notify.Close()
+	}
+	lw.cancel = nil
+}
+
+// Snapshot returns a copy of the current statistics.
+func (lw *Watcher) Snapshot() Snapshot {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	snap := lw.snapshot()
+	snap.Dropped = lw.Dropped()
+	return snap
+}
+
+// Dropped returns the number of lines dropped by the overflow policy.
+func (lw *Watcher) Dropped() uint64 {
+	if lw.pipeline == nil {
+		return 0
+	}
+	return lw.pipeline.Dropped()
+}
+
+func (lw *Watcher) snapshot() Snapshot {
+	snap := Snapshot{
+		Time:          lw.Now(),
+		Elapsed:       time.Since(lw.StartTime),
+		Total:         *lw.StatsTotal,
+		Avg:           *lw.StatsAvg,
+		Errors:        *lw.StatsErrors,
+		Sources:       make(map[string]StatsTotal, len(lw.Sources)),
+		AlertState:    lw.AlertState,
+		AlertMsg:      append([]string(nil), lw.AlertMsg...),
+		AlertPending:  !lw.AlertState && lw.alert.isPending(),
+		AlertFlapping: lw.alert.flapping,
+	}
+	snap.Errors.ErrorReasons = make(map[string]int, len(lw.ErrorReasons))
+	for reason, count := range lw.ErrorReasons {
+		snap.Errors.ErrorReasons[reason] = count
+	}
+	snap.Errors.ErrorSamples = append([]string(nil), lw.ErrorSamples...)
+	for name, stats := range lw.Sources {
+		snap.Sources[name] = *stats
+	}
+	for _, rule := range lw.Rules {
+		state := *rule
+		state.History = append([]string(nil), rule.History...)
+		state.tracker = alertTracker{}
+		snap.Rules = append(snap.Rules, state)
+	}
+	return snap
+}
+
+// section returns what's before the second '/' of a request path.
+func section(request string) string {
+	parts := strings.SplitN(request, "/", 3)
+	if len(parts) < 2 {
+		return "/"
+	}
+	return "/" + parts[1]
+}
+
+func (lw *Watcher) TimeElapsed() string {
+	return time.Duration(time.Duration(time.Now().Unix()-lw.StartTime.Unix()) * time.Second).String()
+}
+
+// Now returns the time of the Watcher clock, the wall clock by default.
+func (lw *Watcher) Now() time.Time {
+	if lw.Clock == nil {
+		return time.Now()
+	}
+	return lw.Clock.Now()
+}
+
+func (lw *Watcher) Date() string {
+	return lw.Now().Format(time.StampMilli)
+}
+
+func (lw *Watcher) LoadOnRefresh(item *StatItem, tmpStat *StatsAvg) {
+	tmpStat.AvgHits += item.Hits
+	tmpStat.Avg2xx += item.Status2xx
+	tmpStat.Avg3xx += item.Status3xx
+	tmpStat.Avg4xx += item.Status4xx
+	tmpStat.Avg5xx += item.Status5xx
+
+	lw.StatsTotal.Load(item)
+}
+
+// Load adds the counters of a refresh window to the totals.
+func (st *StatsTotal) Load(item *StatItem) {
+	st.TotalHits += item.Hits
+	st.Total2xx += item.Status2xx
+	st.Total3xx += item.Status3xx
+	st.Total4xx += item.Status4xx
+	st.Total5xx += item.Status5xx
+
+	st.TopSections = item.TopSections
+	st.TopStatus = item.TopStatus
+	st.TopReferrers = item.TopReferrers
+	st.TopUserAgents = item.TopUserAgents
+}
+
+// LoadSources splits the events of a refresh window by source and adds them
+// to the totals of each source.
+func (lw *Watcher) LoadSources(events []*CommonLog) {
+	if lw.Sources == nil {
+		lw.Sources = make(map[string]*StatsTotal)
+	}
+	bySource := make(map[string][]*CommonLog)
+	for _, event := range events {
+		bySource[event.Source] = append(bySource[event.Source], event)
+		if _, ok := lw.Sources[event.Source]; !ok {
+			lw.Sources[event.Source] = &StatsTotal{}
+		}
+	}
+	for source, stats := range lw.Sources {
+		sourceEvents := bySource[source]
+		stats.Load(lw.CollectStatItems(&sourceEvents))
+	}
+}
+
+// SourceNames returns the sorted names of the log sources seen so far.
+func (lw *Watcher) SourceNames() []string {
+	names := make([]string, 0, len(lw.Sources))
+	for name := range lw.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (lw *Watcher) LoadOnParseError(perr ParseError) {
+	lw.TotalErrors++
+	lw.ErrorReasons[perr.Reason]++
+	lw.ErrorSamples = append(lw.ErrorSamples, perr.Line)
+	if len(lw.ErrorSamples) > maxErrorSamples {
+		lw.ErrorSamples = lw.ErrorSamples[len(lw.ErrorSamples)-maxErrorSamples:]
+	}
+}
+
+// LoadOnAlert turns the counts of an alert window into the average counts of
+// its refresh windows.
+func (lw *Watcher) LoadOnAlert(tmpStat *StatsAvg) {
+	lw.AvgHits = tmpStat.AvgHits / lw.CollectionNum
+	lw.Avg2xx = tmpStat.Avg2xx / lw.CollectionNum
+	lw.Avg3xx = tmpStat.Avg3xx / lw.CollectionNum
+	lw.Avg4xx = tmpStat.Avg4xx / lw.CollectionNum
+	lw.Avg5xx = tmpStat.Avg5xx / lw.CollectionNum
+
+	// The busiest source of the window, counted in SourceHits, is named in
+	// the alert messages.
+	lw.AlertSource = ""
+	if len(lw.SourceHits) > 1 {
+		lw.AlertSource = sortCounts(lw.SourceHits)[0].key
+	}
+}
+
+// WindowEvents removes from logStats and returns the events which happened
+// before watermark. Events older than the previous watermark arrived too late
+// for their window and are only counted.
+func (lw *Watcher) WindowEvents(logStats *[]*CommonLog, watermark time.Time) []*CommonLog {
+	events := lw.windowEvents(logStats, watermark)
+	lw.Watermark = watermark
+
+	return events
+}
+
+// windowEvents is WindowEvents without moving the watermark, so that the
+// lines of several shards are windowed against the same one.
+func (lw *Watcher) windowEvents(logStats *[]*CommonLog, watermark time.Time) []*CommonLog {
+	events := make([]*CommonLog, 0)
+	pending := (*logStats)[:0]
+
+	for _, event := range *logStats {
+		switch {
+		case event.Time.Before(lw.Watermark):
+			lw.TotalLate++
+			if stats, ok := lw.Sources[event.Source]; ok {
+				stats.TotalLate++
+			}
+		case event.Time.Before(watermark):
+			events = append(events, event)
+		default:
+			pending = append(pending, event)
+		}
+	}
+	*logStats = pending
+
+	return events
+}
+
+// CheckAlert compares the average hits of the last alert window with the
+// alert threshold. It records an alert message while the average is above the
+// threshold, and a recover message once it drops back to the recover
+// threshold, and returns the message recorded if any. Alerts name the busiest
+// source when there are several.
+func (lw *Watcher) CheckAlert() string {
+	if lw.AvgHits > lw.AlertThreshold {
+		msg := fmt.Sprintf("High traffic generated an alert - average hits = %d, triggered at %s",
+			lw.AvgHits, lw.Date())
+		if lw.AlertSource != "" {
+			msg += ", busiest source " + lw.AlertSource
+		}
+		lw.AlertMsg = append(lw.AlertMsg, msg)
+		lw.AlertState = true
+		return msg
+	}
+	if lw.AvgHits <= lw.recoverThreshold() && lw.AlertState {
+		msg := fmt.Sprintf("Low traffic generated a recover - average hits = %d, triggered at %s",
+			lw.AvgHits, lw.Date())
+		lw.AlertMsg = append(lw.AlertMsg, msg)
+		lw.AlertState = false
+		return msg
+	}
+	return ""
+}
+
+// recoverThreshold returns the average hits at or below which the high
+// traffic alert recovers.
+func (lw *Watcher) recoverThreshold() int {
+	if lw.AlertRecoverThreshold > 0 {
+		return lw.AlertRecoverThreshold
+	}
+	return lw.AlertThreshold
+}
+
+// evaluateAlert steps the high traffic alert at now, and returns the message
+// of the alert raised or recovered, or of the alert starting or stopping to
+// flap, if any. Changes of the alert are not reported while it flaps.
+func (lw *Watcher) evaluateAlert(now time.Time) (string, alertEvent) {
+	firing, event := lw.alert.step(lw.AlertState, lw.AvgHits > lw.AlertThreshold,
+		lw.AvgHits <= lw.recoverThreshold(), now)
+	var msg string
+	switch event {
+	case eventFire, eventRecover:
+		return lw.CheckAlert(), event
+	case eventFlapStart:
+		msg = fmt.Sprintf("High traffic alert is flapping - %d changes in %s, alerts suppressed, average hits = %d, triggered at %s",
+			len(lw.alert.changes), lw.alert.flapWindow, lw.AvgHits, lw.Date())
+	case eventFlapEnd:
+		msg = fmt.Sprintf("High traffic alert stopped flapping - average hits = %d, triggered at %s", lw.AvgHits, lw.Date())
+	}
+	lw.AlertState = firing
+	if msg != "" {
+		lw.AlertMsg = append(lw.AlertMsg, msg)
+	}
+	return msg, event
+}
+
+func (lw *Watcher) CollectStatItems(logStats *[]*CommonLog) *StatItem {
+	item := newStatItem()
+	for _, event := range *logStats {
+		item.add(event)
+	}
+	return item
+}
+
+func newStatItem() *StatItem {
+	return &StatItem{
+		Timestamp:     time.Now(),
+		TopSections:   make(map[string]int),
+		TopStatus:     make(map[string]int),
+		TopReferrers:  make(map[string]int),
+		TopUserAgents: make(map[string]int),
+	}
+}
+
+// add counts event in the item.
+func (item *StatItem) add(event *CommonLog) {
+	switch event.Status / 100 {
+	case 2:
+		item.Status2xx++
+	case 3:
+		item.Status3xx++
+	case 4:
+		item.Status4xx++
+	case 5:
+		item.Status5xx++
+	}
+	item.Hits++
+	item.Bytes += event.Bytes
+	item.TopSections[section(event.Request)]++
+	item.TopStatus[strconv.Itoa(event.Status)]++
+	if event.Referrer != "" && event.Referrer != "-" {
+		item.TopReferrers[event.Referrer]++
+	}
+	if event.UserAgent != "" && event.UserAgent != "-" {
+		item.TopUserAgents[event.UserAgent]++
+	}
+}
+
+func (lw *Watcher) PurgeTmpStat(tmpStat *StatsAvg) {
+	tmpStat.AvgHits = 0
+	tmpStat.Avg2xx = 0
+	tmpStat.Avg3xx = 0
+	tmpStat.Avg4xx = 0
+	tmpStat.Avg5xx = 0
+
+	lw.SourceHits = nil
+}
+
+// statsAggregator is the Aggregator of a Watcher. Its shards keep the lines
+// not counted yet and the sliding alert window.
+type statsAggregator struct {
+	lw *Watcher
+
+	mu     sync.Mutex
+	shards []*statsShard
+}
+
+// statsShard keeps the lines parsed by one worker until their refresh window
+// closes, and counts them in its sliding alert window as they come.
+type statsShard struct {
+	lw       *Watcher
+	mu       sync.Mutex
+	logStats []*CommonLog
+	window   *slidingWindow
+}
+
+// newStatsAggregator returns the aggregator of lw, with a first shard holding
+// the lines of its checkpoint, if any.
+func newStatsAggregator(lw *Watcher) *statsAggregator {
+	agg := &statsAggregator{lw: lw}
+	shard := agg.NewShard().(*statsShard)
+	if state := lw.Checkpoint; state != nil {
+		shard.logStats = append(shard.logStats, state.Pending...)
+		for _, event := range state.Pending {
+			shard.window.add(event)
+		}
+	}
+	return agg
+}
+
+func (agg *statsAggregator) NewShard() Shard {
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	// The window keeps the lines of the longest alert window ending at the
+	// watermark, and the lines already read past it.
+	cfg := agg.lw.Config
+	seconds := cfg.AlertInterval
+	rules := make([]Rule, len(agg.lw.Rules))
+	for i, state := range agg.lw.Rules {
+		rules[i] = state.Rule
+		if state.Window > seconds {
+			seconds = state.Window
+		}
+	}
+	shard := &statsShard{
+		lw:     agg.lw,
+		window: newSlidingWindow(seconds+cfg.AllowedLateness+cfg.RefreshInterval, rules),
+	}
+	agg.shards = append(agg.shards, shard)
+	return shard
+}
+
+func (agg *statsAggregator) allShards() []*statsShard {
+	agg.mu.Lock()
+	defer agg.mu.Unlock()
+
+	return append([]*statsShard(nil), agg.shards...)
+}
+
+func (shard *statsShard) Add(item CommonLog) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.logStats = append(shard.logStats, &item)
+	shard.window.add(&item)
+}
+
+// AddError counts the rejected lines right away, since they are not
+// windowed.
+func (shard *statsShard) AddError(perr ParseError) {
+	shard.lw.mu.Lock()
+	defer shard.lw.mu.Unlock()
+
+	shard.lw.LoadOnParseError(perr)
+}
+
+// Refresh takes the lines of the window out of every shard, counts them one
+// shard per goroutine, and merges the counts.
+func (agg *statsAggregator) Refresh(now time.Time) Snapshot {
+	lw := agg.lw
+	shards := agg.allShards()
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	// Log times are whole seconds, so the watermark stays at the last second
+	// closed before now, and the lines of the current second are counted by
+	// the next refresh rather than as late.
+	lateness := time.Duration(lw.AllowedLateness) * time.Second
+	watermark := now.Add(-lateness).Truncate(time.Second)
+	events := make([][]*CommonLog, len(shards))
+	for i, shard := range shards {
+		shard.mu.Lock()
+		events[i] = lw.windowEvents(&shard.logStats, watermark)
+		shard.mu.Unlock()
+	}
+	lw.Watermark = watermark
+
+	items := make([]*StatItem, len(shards))
+	var counters sync.WaitGroup
+	for i := range shards {
+		counters.Add(1)
+		go func(i int) {
+			defer counters.Done()
+			items[i] = lw.CollectStatItems(&events[i])
+		}(i)
+	}
+	counters.Wait()
+
+	all := make([]*CommonLog, 0)
+	for i := range shards {
+		if i > 0 {
+			items[0].Merge(items[i])
+		}
+		all = append(all, events[i]...)
+	}
+	lw.StatsTotal.Load(items[0])
+	lw.LoadSources(all)
+	return lw.snapshot()
+}
+
+// Alert sums the sliding alert window ending at the watermark of now, and the
+// windows of the alert rules. Since they are evaluated every second, alerts
+// are raised or recovered when the state of an alert changes, not again while
+// it holds.
+func (agg *statsAggregator) Alert(now time.Time) ([]Alert, Snapshot) {
+	lw := agg.lw
+	shards := agg.allShards()
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	// Like the refresh watermark, the window ends on the last second closed,
+	// so that it holds AlertInterval seconds.
+	end := now.Add(-time.Duration(lw.AllowedLateness) * time.Second).Truncate(time.Second)
+	length := time.Duration(lw.AlertInterval) * time.Second
+	counts := StatsAvg{}
+	rules := make([]ruleCounts, len(lw.Rules))
+	lw.SourceHits = make(map[string]int)
+	for _, shard := range shards {
+		shard.mu.Lock()
+		shard.window.sum(end, length, &counts, lw.SourceHits)
+		for i := range rules {
+			shard.window.sumRule(end, i, &rules[i])
+		}
+		shard.mu.Unlock()
+	}
+	lw.LoadOnAlert(&counts)
+
+	alerts := make([]Alert, 0)
+	if msg, event := lw.evaluateAlert(now); msg != "" {
+		alerts = append(alerts, Alert{
+			Time:      now,
+			Recovered: !lw.AlertState,
+			AvgHits:   lw.AvgHits,
+			Source:    lw.AlertSource,
+			Flapping:  event == eventFlapStart || event == eventFlapEnd,
+			Message:   msg,
+		})
+	}
+	for i, rule := range lw.Rules {
+		var item *StatItem
+		if rule.expr != nil {
+			item = newStatItem()
+			for _, shard := range shards {
+				shard.mu.Lock()
+				shard.window.sumItem(end, i, item)
+				shard.mu.Unlock()
+			}
+		}
+		if msg, event := rule.evaluate(rules[i], item, now); msg != "" {
+			alerts = append(alerts, Alert{
+				Time:      now,
+				Recovered: !rule.Firing,
+				Source:    rule.Source,
+				Rule:      rule.Name,
+				Severity:  rule.Severity,
+				Value:     rule.Value,
+				Flapping:  event == eventFlapStart || event == eventFlapEnd,
+				Message:   msg,
+			})
+		}
+	}
+	for _, alert := range alerts {
+		log.Println(alert.Message)
+	}
+	return alerts, lw.snapshot()
+}
+
+// checkpoint saves the positions read in the files, and the lines not
+// counted yet, to the state file. The files not read since the last run keep
+// the positions of its checkpoint.
+func (agg *statsAggregator) checkpoint(read map[string]FilePosition) {
+	lw := agg.lw
+	shards := agg.allShards()
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	files := make(map[string]FilePosition)
+	if lw.Checkpoint != nil {
+		for file, pos := range lw.Checkpoint.Files {
+			files[file] = pos
+		}
+	}
+	for file, pos := range read {
+		files[file] = pos
+	}
+	pending := make([]*CommonLog, 0)
+	for _, shard := range shards {
+		shard.mu.Lock()
+		pending = append(pending, shard.logStats...)
+		shard.mu.Unlock()
+	}
+	lw.SaveState(lw.StateFile, files, pending)
+}
@@ -0,0 +1,157 @@
+package logwatcher
+
+import (
+	"math"
+	"time"
+)
+
+// slidingWindow counts the lines of the last seconds in a ring of one second
+// buckets, so that the counts of the window ending at any second can be summed
+// at any time.
+type slidingWindow struct {
+	buckets []windowBucket
+	rules   []Rule
+	// items tells whether the buckets keep a StatItem, for the rules with a
+	// condition.
+	items bool
+}
+
+// windowBucket holds the counts of the lines of one second, by status class,
+// by source and by alert rule, and their StatItem when the window keeps them.
+type windowBucket struct {
+	second  int64
+	counts  StatsAvg
+	sources map[string]int
+	rules   []ruleCounts
+	item    *StatItem
+}
+
+// ruleCounts is the sum of the metric of a rule over the lines matching its
+// filters, and the number of these lines.
+type ruleCounts struct {
+	value float64
+	hits  int
+}
+
+// newSlidingWindow returns a window keeping the counts of the given number of
+// seconds, and the counts of rules.
+func newSlidingWindow(seconds int, rules []Rule) *slidingWindow {
+	if seconds < 1 {
+		seconds = 1
+	}
+	w := &slidingWindow{buckets: make([]windowBucket, seconds), rules: rules}
+	for _, rule := range rules {
+		if rule.expr != nil {
+			w.items = true
+		}
+	}
+	for i := range w.buckets {
+		w.buckets[i] = windowBucket{
+			second:  math.MinInt64,
+			sources: make(map[string]int),
+			rules:   make([]ruleCounts, len(rules)),
+		}
+	}
+	return w
+}
+
+// add counts event in the bucket of its second. Events older than the seconds
+// kept by the window are not counted.
+func (w *slidingWindow) add(event *CommonLog) {
+	second := event.Time.Unix()
+	b := &w.buckets[w.index(second)]
+	switch {
+	case second < b.second:
+		return
+	case second > b.second:
+		b.second = second
+		b.counts = StatsAvg{}
+		for source := range b.sources {
+			delete(b.sources, source)
+		}
+		for i := range b.rules {
+			b.rules[i] = ruleCounts{}
+		}
+		b.item = nil
+	}
+
+	b.counts.AvgHits++
+	switch event.Status / 100 {
+	case 2:
+		b.counts.Avg2xx++
+	case 3:
+		b.counts.Avg3xx++
+	case 4:
+		b.counts.Avg4xx++
+	case 5:
+		b.counts.Avg5xx++
+	}
+	b.sources[event.Source]++
+	if w.items {
+		if b.item == nil {
+			b.item = newStatItem()
+		}
+		b.item.add(event)
+	}
+	for i := range w.rules {
+		if rule := &w.rules[i]; rule.expr == nil && rule.matches(event) {
+			b.rules[i].value += rule.metric(event)
+			b.rules[i].hits++
+		}
+	}
+}
+
+// sum adds the counts of the seconds of the length of time before end, a
+// whole second, to counts and sources.
+func (w *slidingWindow) sum(end time.Time, length time.Duration, counts *StatsAvg, sources map[string]int) {
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if !b.within(end, length) {
+			continue
+		}
+		counts.AvgHits += b.counts.AvgHits
+		counts.Avg2xx += b.counts.Avg2xx
+		counts.Avg3xx += b.counts.Avg3xx
+		counts.Avg4xx += b.counts.Avg4xx
+		counts.Avg5xx += b.counts.Avg5xx
+		for source, hits := range b.sources {
+			sources[source] += hits
+		}
+	}
+}
+
+// sumRule adds the counts of rule i over its window ending at end to counts.
+func (w *slidingWindow) sumRule(end time.Time, i int, counts *ruleCounts) {
+	length := time.Duration(w.rules[i].Window) * time.Second
+	for j := range w.buckets {
+		if b := &w.buckets[j]; b.within(end, length) {
+			counts.value += b.rules[i].value
+			counts.hits += b.rules[i].hits
+		}
+	}
+}
+
+// sumItem merges the items of the seconds of the window of rule i ending at
+// end into item.
+func (w *slidingWindow) sumItem(end time.Time, i int, item *StatItem) {
+	length := time.Duration(w.rules[i].Window) * time.Second
+	for j := range w.buckets {
+		if b := &w.buckets[j]; b.item != nil && b.within(end, length) {
+			item.Merge(b.item)
+		}
+	}
+}
+
+// within tells whether the second of the bucket overlaps the length of time
+// before end.
+func (b *windowBucket) within(end time.Time, length time.Duration) bool {
+	return b.second >= end.Add(-length).Unix() && b.second <= end.Add(-time.Nanosecond).Unix()
+}
+
+func (w *slidingWindow) index(second int64) int {
+	i := second % int64(len(w.buckets))
+	if i < 0 {
+		i += int64(len(w.buckets))
+	}
+	return int(i)
+}
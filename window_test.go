@@ -0,0 +1,90 @@
+package logwatcher
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type WindowSuite struct{}
+
+var _ = Suite(&WindowSuite{})
+
+var windowStart = time.Date(2016, 5, 11, 22, 0, 0, 0, time.UTC)
+
+func (s *WindowSuite) TestSlidingWindowSum(c *C) {
+	w := newSlidingWindow(10, nil)
+	for i := 0; i < 5; i++ {
+		w.add(&CommonLog{Status: 200 + 100*(i%4), Source: "a", Time: windowStart.Add(time.Duration(i) * time.Second)})
+	}
+	w.add(&CommonLog{Status: 200, Source: "b", Time: windowStart.Add(4*time.Second + 500*time.Millisecond)})
+
+	counts, sources := StatsAvg{}, make(map[string]int)
+	w.sum(windowStart.Add(5*time.Second), 5*time.Second, &counts, sources)
+	c.Assert(counts, DeepEquals, StatsAvg{AvgHits: 6, Avg2xx: 3, Avg3xx: 1, Avg4xx: 1, Avg5xx: 1})
+	c.Assert(sources, DeepEquals, map[string]int{"a": 5, "b": 1})
+
+	counts, sources = StatsAvg{}, make(map[string]int)
+	w.sum(windowStart.Add(4*time.Second), 2*time.Second, &counts, sources)
+	c.Assert(counts.AvgHits, Equals, 2)
+}
+
+func (s *WindowSuite) TestSlidingWindowExpire(c *C) {
+	w := newSlidingWindow(3, nil)
+	w.add(&CommonLog{Time: windowStart})
+	w.add(&CommonLog{Time: windowStart.Add(3 * time.Second)})
+	// Seconds the ring no longer keeps are not counted.
+	w.add(&CommonLog{Time: windowStart})
+
+	counts := StatsAvg{}
+	w.sum(windowStart.Add(4*time.Second), 10*time.Second, &counts, make(map[string]int))
+	c.Assert(counts.AvgHits, Equals, 1)
+}
+
+// Alert windows evaluated within a second hold AlertInterval seconds.
+func (s *WindowSuite) TestStatsAggregatorAlertSeconds(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 10, AlertThreshold: 100})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+	for i := 0; i < 20; i++ {
+		shard.Add(CommonLog{Status: 200, Time: windowStart.Add(time.Duration(i) * time.Second)})
+	}
+
+	_, snap := agg.Alert(windowStart.Add(20*time.Second + 300*time.Millisecond))
+	c.Assert(snap.Avg.AvgHits, Equals, 10)
+}
+
+// A spike straddling two alert intervals raises an alert as soon as the
+// hits of the last alert interval exceed the threshold, which tumbling alert
+// windows would miss.
+func (s *WindowSuite) TestStatsAggregatorAlertSliding(c *C) {
+	lw, err := New(&Config{LogFormat: "clf", RefreshInterval: 10, AlertInterval: 120, AlertThreshold: 10})
+	c.Assert(err, IsNil)
+	agg := newStatsAggregator(lw)
+	shard := agg.NewShard()
+	for i := 0; i < 200; i++ {
+		shard.Add(CommonLog{Status: 200, Time: windowStart.Add(110*time.Second + time.Duration(i)*100*time.Millisecond)})
+	}
+
+	alerts := make([]Alert, 0)
+	for t := 1; t <= 300; t++ {
+		raised, snap := agg.Alert(windowStart.Add(time.Duration(t) * time.Second))
+		alerts = append(alerts, raised...)
+		if t == 120 || t == 240 {
+			c.Assert(snap.AlertState, Equals, false)
+		}
+	}
+
+	// The 140 hits of the first 14s of the spike make an average of 11 hits
+	// per refresh, and the 130 of its last 13s an average of 10, which
+	// recovers at the threshold.
+	c.Assert(alerts, HasLen, 2)
+	c.Assert(alerts[0].Recovered, Equals, false)
+	c.Assert(alerts[0].Time.Equal(windowStart.Add(124*time.Second)), Equals, true)
+	c.Assert(alerts[0].AvgHits, Equals, 11)
+	c.Assert(alerts[1].Recovered, Equals, true)
+	c.Assert(alerts[1].Time.Equal(windowStart.Add(237*time.Second)), Equals, true)
+	c.Assert(alerts[1].AvgHits, Equals, 10)
+	c.Assert(lw.AlertMsg, HasLen, 2)
+}